@@ -2,25 +2,82 @@ package micro
 
 import (
 	"bytes"
+	"crypto/hmac"
 	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/micro/packet"
 	"github.com/micro/xutils"
 )
 
+const (
+	// authSkew 校验码有效时长及允许的时钟偏差(秒)
+	authSkew = 6
+	// authExpired Token默认有效期(秒)
+	authExpired = 7 * 24 * 3600
+)
+
+// authKeyID 密钥标识只能包含字母、数字、'-'及'_'
+var authKeyID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
 type authorize struct {
 	mask []byte
+
+	// HMAC-SHA256签名密钥，第一个用于签发，其余仅用于校验(密钥轮换)
+	keys []authKey
+	// 是否接受旧格式(md5)的校验码及Token
+	legacy bool
+	// Token有效期(秒)
+	expired int64
+}
+
+type authKey struct {
+	id     string
+	secret []byte
+}
+
+// TokenClaims Token携带的信息
+type TokenClaims struct {
+	UID      string   `json:"uid,omitempty"`   // 用户标识
+	Server   string   `json:"srv,omitempty"`   // 限定使用的服务名称，为空不限制
+	Device   string   `json:"dev,omitempty"`   // 设备标识
+	Roles    []string `json:"roles,omitempty"` // 会话角色
+	Code     string   `json:"code,omitempty"`  // 服务间校验码(NewCode)
+	IssuedAt int64    `json:"iat"`             // 签发时间(Unix秒)
+	Expires  int64    `json:"exp"`             // 过期时间(Unix秒)，为0时使用配置的有效期
 }
 
 // Init 初始化
-func (a *authorize) Init(mask string) {
+// keys为签名密钥(kid:secret)，为空时只使用旧格式
+// legacy为true时同时接受旧格式的校验码及Token(迁移模式)
+func (a *authorize) Init(mask string, keys []string, legacy bool, expired int) {
 	if mask == "" {
 		mask = "JCZ2020"
 	}
 	a.mask = []byte(mask)
+
+	a.keys = a.keys[:0]
+	for _, k := range keys {
+		i := strings.IndexByte(k, ':')
+		if i <= 0 || i == len(k)-1 || !authKeyID.MatchString(k[:i]) {
+			Debug("invalid auth key, expected kid:secret")
+			continue
+		}
+		a.keys = append(a.keys, authKey{id: k[:i], secret: []byte(k[i+1:])})
+	}
+	a.legacy = legacy || len(a.keys) == 0
+
+	a.expired = int64(expired)
+	if a.expired <= 0 {
+		a.expired = authExpired
+	}
 }
 
 // NewCode 生成校验码
@@ -33,12 +90,155 @@ func NewToken(code string) string {
 	return env.authorize.NewToken(code)
 }
 
+// NewTokenWithClaims 生成携带附加信息的Token
+// 未配置签名密钥时只保留UID
+func NewTokenWithClaims(claims TokenClaims) string {
+	return env.authorize.NewTokenWithClaims(claims)
+}
+
+// ParseToken 校验Token并返回携带的信息
+func ParseToken(token string) (TokenClaims, bool) {
+	return env.authorize.ParseToken(token)
+}
+
 // NewCode 成生校验码
 func (a *authorize) NewCode(code string) string {
 	if code == "" {
 		code = xutils.GUID(0)
 	}
+	if len(a.keys) == 0 {
+		return a.newLegacyCode(code)
+	}
 
+	now := time.Now().Unix()
+	return a.sign(&TokenClaims{Code: code, IssuedAt: now, Expires: now + authSkew})
+}
+
+// Check 校验码值是否合法
+func (a *authorize) Check(as string) (code string, ok bool) {
+	if a.isSigned(as) {
+		var claims TokenClaims
+		if claims, ok = a.verify(as); !ok || claims.Code == "" {
+			return "", false
+		}
+		return claims.Code, true
+	}
+	if !a.legacy {
+		return
+	}
+	return a.checkLegacyCode(as)
+}
+
+// NewToken 创建Token
+func (a *authorize) NewToken(s string) string {
+	return a.NewTokenWithClaims(TokenClaims{UID: s})
+}
+
+// NewTokenWithClaims 创建携带附加信息的Token
+func (a *authorize) NewTokenWithClaims(claims TokenClaims) string {
+	if len(a.keys) == 0 {
+		return a.newLegacyToken(claims.UID)
+	}
+
+	claims.Code = ""
+	if claims.IssuedAt == 0 {
+		claims.IssuedAt = time.Now().Unix()
+	}
+	if claims.Expires == 0 {
+		claims.Expires = claims.IssuedAt + a.expired
+	}
+	return a.sign(&claims)
+}
+
+// CheckToken 校验Token是否合法
+func (a *authorize) CheckToken(as string) (uid string, ok bool) {
+	claims, ok := a.ParseToken(as)
+	return claims.UID, ok
+}
+
+// ParseToken 校验Token并返回携带的信息
+func (a *authorize) ParseToken(as string) (claims TokenClaims, ok bool) {
+	if a.isSigned(as) {
+		claims, ok = a.verify(as)
+		if !ok || claims.Code != "" {
+			return TokenClaims{}, false
+		}
+		if claims.Server != "" && claims.Server != env.config.Name {
+			return TokenClaims{}, false
+		}
+		return
+	}
+	if !a.legacy {
+		return
+	}
+	claims.UID, ok = a.checkLegacyToken(as)
+	return
+}
+
+// isSigned 是否为HMAC签名格式(kid.payload.sign)
+func (a *authorize) isSigned(as string) bool {
+	return strings.IndexByte(as, '.') > 0
+}
+
+// sign 签发: kid.base64(payload).base64(hmac-sha256(kid.payload))
+func (a *authorize) sign(claims *TokenClaims) string {
+	key := &a.keys[0]
+	data, _ := json.Marshal(claims)
+
+	var sb strings.Builder
+	sb.WriteString(key.id)
+	sb.WriteByte('.')
+	sb.WriteString(base64.RawURLEncoding.EncodeToString(data))
+	mac := hmac.New(sha256.New, key.secret)
+	mac.Write(xutils.UnsafeStringToBytes(sb.String()))
+	sb.WriteByte('.')
+	sb.WriteString(base64.RawURLEncoding.EncodeToString(mac.Sum(nil)))
+	return sb.String()
+}
+
+// verify 校验签名及有效期
+func (a *authorize) verify(as string) (claims TokenClaims, ok bool) {
+	i := strings.IndexByte(as, '.')
+	j := strings.LastIndexByte(as, '.')
+	if i <= 0 || j <= i {
+		return
+	}
+
+	// 按kid查找密钥
+	var key *authKey
+	for k := range a.keys {
+		if a.keys[k].id == as[:i] {
+			key = &a.keys[k]
+			break
+		}
+	}
+	if key == nil {
+		return
+	}
+
+	sign, err := base64.RawURLEncoding.DecodeString(as[j+1:])
+	if err != nil {
+		return
+	}
+	mac := hmac.New(sha256.New, key.secret)
+	mac.Write(xutils.UnsafeStringToBytes(as[:j]))
+	if !hmac.Equal(sign, mac.Sum(nil)) {
+		return
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(as[i+1 : j])
+	if err != nil || json.Unmarshal(data, &claims) != nil {
+		return
+	}
+	now := time.Now().Unix()
+	if now > claims.Expires || claims.IssuedAt > now+authSkew {
+		return TokenClaims{}, false
+	}
+	return claims, true
+}
+
+// newLegacyCode 生成旧格式(md5)的校验码
+func (a *authorize) newLegacyCode(code string) string {
 	pack := packet.New(512)
 	// add code
 	pack.WriteString(code)
@@ -64,8 +264,8 @@ func (a *authorize) NewCode(code string) string {
 	return s
 }
 
-// Check 校验码值是否合法
-func (a *authorize) Check(as string) (code string, ok bool) {
+// checkLegacyCode 校验旧格式(md5)的校验码
+func (a *authorize) checkLegacyCode(as string) (code string, ok bool) {
 	if as == "" {
 		return
 	}
@@ -109,8 +309,8 @@ func (a *authorize) Check(as string) (code string, ok bool) {
 	return
 }
 
-// NewToken 创建Token
-func (a *authorize) NewToken(s string) string {
+// newLegacyToken 创建旧格式(md5)的Token
+func (a *authorize) newLegacyToken(s string) string {
 	pack := packet.New(512)
 	pack.WriteString(s)
 	size := pack.Size()
@@ -130,8 +330,8 @@ func (a *authorize) NewToken(s string) string {
 	return token
 }
 
-// CheckToken 校验Token是否合法
-func (a *authorize) CheckToken(as string) (token string, ok bool) {
+// checkLegacyToken 校验旧格式(md5)的Token
+func (a *authorize) checkLegacyToken(as string) (token string, ok bool) {
 	if as == "" {
 		return
 	}
@@ -168,10 +368,51 @@ const (
 	GUEST    = `loginCutGuest`
 )
 
+// 常用的会话角色
+const (
+	RolePlayer   = `player`
+	RoleGM       = `gm`
+	RoleInternal = `internal`
+)
+
 // CheckAPI 是否可以访问API
+// 未配置PublicAPIs时，内置的登录类接口允许未登录访问
 func (a *authorize) CheckAPI(uid, api string) bool {
 	if uid != "" {
 		return true
 	}
-	return api == VERSION || api == LOGIN || api == LoginDBA || api == REGISTER || api == GUEST
+	if b, ok := env.bis[api]; ok && b.opts.public {
+		return true
+	}
+	if len(env.config.PublicAPIs) == 0 {
+		return api == VERSION || api == LOGIN || api == LoginDBA || api == REGISTER || api == GUEST
+	}
+	for _, s := range env.config.PublicAPIs {
+		if s == api {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckRoles 会话角色是否可以访问API
+// 配置中的APIRoles优先于注册时设置的角色，没有设置角色的API不做限制
+func (a *authorize) CheckRoles(api string, roles []string) bool {
+	need, ok := env.config.APIRoles[api]
+	if !ok {
+		if b, ok := env.bis[api]; ok {
+			need = b.opts.roles
+		}
+	}
+	if len(need) == 0 {
+		return true
+	}
+	for _, r := range need {
+		for _, s := range roles {
+			if r == s {
+				return true
+			}
+		}
+	}
+	return false
 }
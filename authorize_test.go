@@ -0,0 +1,134 @@
+package micro
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthorizeToken(t *testing.T) {
+	saved := env.config
+	defer func() { env.config = saved }()
+	env.config.Name = "game"
+
+	var legacy, signed, rotated, migrate authorize
+	legacy.Init("mask", nil, false, 0)
+	signed.Init("mask", []string{"k1:secret1"}, false, 0)
+	rotated.Init("mask", []string{"k2:secret2", "k1:secret1"}, false, 0)
+	migrate.Init("mask", []string{"k1:secret1"}, true, 0)
+
+	now := time.Now().Unix()
+	token := signed.NewToken("u1")
+	tests := []struct {
+		name  string
+		a     *authorize
+		token string
+		uid   string
+		ok    bool
+	}{
+		{"legacy", &legacy, legacy.NewToken("u1"), "u1", true},
+		{"signed", &signed, token, "u1", true},
+		{"rotated key", &rotated, token, "u1", true},
+		{"unknown kid", &legacy, token, "", false},
+		{"legacy rejected", &signed, legacy.NewToken("u1"), "", false},
+		{"legacy migrate", &migrate, legacy.NewToken("u1"), "u1", true},
+		{"tampered payload", &signed, tamper(token, 1), "", false},
+		{"tampered sign", &signed, tamper(token, 2), "", false},
+		{"expired", &signed, signed.NewTokenWithClaims(TokenClaims{UID: "u1", IssuedAt: now - 10, Expires: now - 1}), "", false},
+		{"future", &signed, signed.NewTokenWithClaims(TokenClaims{UID: "u1", IssuedAt: now + 60}), "", false},
+		{"server", &signed, signed.NewTokenWithClaims(TokenClaims{UID: "u1", Server: "game"}), "u1", true},
+		{"other server", &signed, signed.NewTokenWithClaims(TokenClaims{UID: "u1", Server: "chat"}), "", false},
+		{"code as token", &signed, signed.NewCode("c1"), "", false},
+		{"empty", &legacy, "", "", false},
+	}
+	for _, tt := range tests {
+		uid, ok := tt.a.CheckToken(tt.token)
+		if uid != tt.uid || ok != tt.ok {
+			t.Errorf("%s: CheckToken = %q, %v; want %q, %v", tt.name, uid, ok, tt.uid, tt.ok)
+		}
+	}
+}
+
+func TestAuthorizeClaims(t *testing.T) {
+	var a authorize
+	a.Init("", []string{"k1:secret1"}, false, 60)
+
+	token := a.NewTokenWithClaims(TokenClaims{UID: "u1", Device: "d1", Roles: []string{RoleGM}, Code: "c1"})
+	claims, ok := a.ParseToken(token)
+	if !ok {
+		t.Fatalf("ParseToken(%q) failed", token)
+	}
+	if claims.UID != "u1" || claims.Device != "d1" || len(claims.Roles) != 1 || claims.Roles[0] != RoleGM {
+		t.Errorf("claims = %+v", claims)
+	}
+	if claims.Code != "" {
+		t.Errorf("claims.Code = %q, want empty", claims.Code)
+	}
+	if d := claims.Expires - claims.IssuedAt; d != 60 {
+		t.Errorf("expires after %ds, want 60s", d)
+	}
+}
+
+func TestAuthorizeCode(t *testing.T) {
+	var legacy, signed, rotated authorize
+	legacy.Init("mask", nil, false, 0)
+	signed.Init("mask", []string{"k1:secret1"}, false, 0)
+	rotated.Init("mask", []string{"k2:secret2", "k1:secret1"}, true, 0)
+
+	code := signed.NewCode("c1")
+	tests := []struct {
+		name string
+		a    *authorize
+		as   string
+		code string
+		ok   bool
+	}{
+		{"legacy", &legacy, legacy.NewCode("c1"), "c1", true},
+		{"signed", &signed, code, "c1", true},
+		{"rotated key", &rotated, code, "c1", true},
+		{"legacy rejected", &signed, legacy.NewCode("c1"), "", false},
+		{"legacy migrate", &rotated, legacy.NewCode("c1"), "c1", true},
+		{"tampered", &signed, tamper(code, 2), "", false},
+		{"token as code", &signed, signed.NewToken("u1"), "", false},
+		{"other mask", &legacy, (&authorize{mask: []byte("other")}).newLegacyCode("c1"), "", false},
+	}
+	for _, tt := range tests {
+		code, ok := tt.a.Check(tt.as)
+		if ok != tt.ok || ok && code != tt.code {
+			t.Errorf("%s: Check = %q, %v; want %q, %v", tt.name, code, ok, tt.code, tt.ok)
+		}
+	}
+}
+
+func TestAuthorizeInitKeys(t *testing.T) {
+	tests := []struct {
+		keys   []string
+		n      int
+		legacy bool
+	}{
+		{nil, 0, true},
+		{[]string{"k1:s1"}, 1, false},
+		{[]string{"k1:s1", "k2:s:2"}, 2, false},
+		{[]string{":s1", "k1:", "k 1:s1", "k1"}, 0, true},
+	}
+	for _, tt := range tests {
+		var a authorize
+		a.Init("", tt.keys, false, 0)
+		if len(a.keys) != tt.n || a.legacy != tt.legacy {
+			t.Errorf("Init(%q): %d keys, legacy %v; want %d, %v", tt.keys, len(a.keys), a.legacy, tt.n, tt.legacy)
+		}
+	}
+}
+
+// tamper 修改签名格式第part段的首字符
+func tamper(as string, part int) string {
+	parts := strings.Split(as, ".")
+	b := []byte(parts[part])
+	if b[0] == 'A' {
+		b[0] = 'B'
+	} else {
+		b[0] = 'A'
+	}
+	parts[part] = string(b)
+	return strings.Join(parts, ".")
+}
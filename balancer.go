@@ -0,0 +1,129 @@
+package micro
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync/atomic"
+)
+
+// 负载均衡策略
+const (
+	// BalanceRoundRobin 轮询(默认)
+	BalanceRoundRobin = 0
+	// BalanceWeighted 按注册时声明的权重轮询
+	BalanceWeighted = 1
+	// BalanceHash 按Key一致性哈希, 相同的Key总是路由到同一实例
+	BalanceHash = 2
+	// BalanceLeastPending 选择未完成RPC请求最少的实例
+	BalanceLeastPending = 3
+)
+
+// ringReplicas 一致性哈希中每个实例的虚拟节点数量
+const ringReplicas = 64
+
+// SetBalance 设置服务的负载均衡策略
+func SetBalance(srvName string, strategy uint8) {
+	env.registry.SetBalance(srvName, strategy)
+}
+
+// ringNode 哈希环节点
+type ringNode struct {
+	hash uint32
+	adr  string
+}
+
+// available 实例是否可以接收请求(非维护状态)
+func (a *addr) available(adr string) bool {
+	info, ok := a.infos[adr]
+	return !ok || info.State != StaCLOSE
+}
+
+// weightOf 实例权重，维护中的实例权重为0
+func (a *addr) weightOf(adr string) uint32 {
+	info, ok := a.infos[adr]
+	if !ok {
+		return 1
+	}
+	if info.State == StaCLOSE {
+		return 0
+	}
+	if info.Weight == 0 {
+		return 1
+	}
+	return info.Weight
+}
+
+// rebuild 重建权重及哈希环
+func (a *addr) rebuild() {
+	a.total = 0
+	a.ring = a.ring[:0]
+	for _, adr := range a.ads {
+		if !a.available(adr) {
+			continue
+		}
+		a.total += a.weightOf(adr)
+		for i := 0; i < ringReplicas; i++ {
+			a.ring = append(a.ring, ringNode{
+				hash: crc32.ChecksumIEEE([]byte(adr + "#" + strconv.Itoa(i))),
+				adr:  adr,
+			})
+		}
+	}
+	sort.Slice(a.ring, func(i, j int) bool {
+		return a.ring[i].hash < a.ring[j].hash
+	})
+}
+
+// pick 按策略选择实例
+func (a *addr) pick(strategy uint8, key string) string {
+	l := len(a.ads)
+	if a.total == 0 {
+		return ""
+	}
+	switch strategy {
+	case BalanceWeighted:
+		n := atomic.AddUint32(&a.found, 1) % a.total
+		for _, adr := range a.ads {
+			w := a.weightOf(adr)
+			if n < w {
+				return adr
+			}
+			n -= w
+		}
+	case BalanceHash:
+		if key == "" || len(a.ring) == 0 {
+			break
+		}
+		h := crc32.ChecksumIEEE([]byte(key))
+		i := sort.Search(len(a.ring), func(i int) bool {
+			return a.ring[i].hash >= h
+		})
+		if i == len(a.ring) {
+			i = 0
+		}
+		return a.ring[i].adr
+	case BalanceLeastPending:
+		start := int(atomic.AddUint32(&a.found, 1) % uint32(l))
+		adr, least := "", int64(-1)
+		for i := 0; i < l; i++ {
+			cur := a.ads[(start+i)%l]
+			if !a.available(cur) {
+				continue
+			}
+			if n := env.rpc.Pending(cur); least < 0 || n < least {
+				adr, least = cur, n
+			}
+		}
+		return adr
+	}
+
+	// 轮询
+	for i := 0; i < l; i++ {
+		adr := a.ads[atomic.AddUint32(&a.found, 1)%uint32(l)]
+		if a.available(adr) {
+			return adr
+		}
+	}
+	return ""
+}
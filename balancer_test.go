@@ -0,0 +1,110 @@
+package micro
+
+import (
+	"strconv"
+	"testing"
+)
+
+// newTestAddr 创建服务实例列表，weights为0的实例处于维护状态
+func newTestAddr(weights ...uint32) *addr {
+	a := &addr{infos: make(map[string]*ServerInfo)}
+	for i, w := range weights {
+		adr := "10.0.0." + strconv.Itoa(i+1)
+		info := &ServerInfo{Address: adr, Weight: w}
+		if w == 0 {
+			info.State = StaCLOSE
+		}
+		a.ads = append(a.ads, adr)
+		a.infos[adr] = info
+	}
+	a.rebuild()
+	return a
+}
+
+func TestBalancePick(t *testing.T) {
+	saved := env.rpc.pending
+	defer func() { env.rpc.pending = saved }()
+	pending := func(ns ...int64) map[string]*int64 {
+		m := make(map[string]*int64)
+		for i := range ns {
+			m["10.0.0."+strconv.Itoa(i+1)] = &ns[i]
+		}
+		return m
+	}
+
+	tests := []struct {
+		name     string
+		strategy uint8
+		weights  []uint32
+		pending  map[string]*int64
+		picks    int
+		want     map[string]int
+	}{
+		{"round robin", BalanceRoundRobin, []uint32{1, 1, 1}, nil, 9,
+			map[string]int{"10.0.0.1": 3, "10.0.0.2": 3, "10.0.0.3": 3}},
+		{"round robin skips closed", BalanceRoundRobin, []uint32{1, 0, 1}, nil, 8,
+			map[string]int{"10.0.0.1": 4, "10.0.0.3": 4}},
+		{"weighted", BalanceWeighted, []uint32{1, 2, 3}, nil, 12,
+			map[string]int{"10.0.0.1": 2, "10.0.0.2": 4, "10.0.0.3": 6}},
+		{"weighted skips closed", BalanceWeighted, []uint32{2, 0, 1}, nil, 6,
+			map[string]int{"10.0.0.1": 4, "10.0.0.3": 2}},
+		{"least pending", BalanceLeastPending, []uint32{1, 1, 1}, pending(3, 1, 2), 4,
+			map[string]int{"10.0.0.2": 4}},
+		{"least pending skips closed", BalanceLeastPending, []uint32{1, 0, 1}, pending(3, 1, 2), 4,
+			map[string]int{"10.0.0.3": 4}},
+		{"hash without key", BalanceHash, []uint32{1, 1}, nil, 4,
+			map[string]int{"10.0.0.1": 2, "10.0.0.2": 2}},
+		{"all closed", BalanceRoundRobin, []uint32{0, 0}, nil, 2,
+			map[string]int{"": 2}},
+		{"all closed weighted", BalanceWeighted, []uint32{0}, nil, 2,
+			map[string]int{"": 2}},
+	}
+	for _, tt := range tests {
+		env.rpc.pending = tt.pending
+		a := newTestAddr(tt.weights...)
+		got := make(map[string]int)
+		for i := 0; i < tt.picks; i++ {
+			got[a.pick(tt.strategy, "")]++
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: picks = %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for adr, n := range tt.want {
+			if got[adr] != n {
+				t.Errorf("%s: picks = %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestBalanceHash(t *testing.T) {
+	a := newTestAddr(1, 1, 1, 1)
+	keys := make([]string, 200)
+	owner := make(map[string]string, len(keys))
+	used := make(map[string]int)
+	for i := range keys {
+		keys[i] = "uid" + strconv.Itoa(i)
+		owner[keys[i]] = a.pick(BalanceHash, keys[i])
+		used[owner[keys[i]]]++
+		if adr := a.pick(BalanceHash, keys[i]); adr != owner[keys[i]] {
+			t.Fatalf("key %s picked %s then %s", keys[i], owner[keys[i]], adr)
+		}
+	}
+	if len(used) != 4 {
+		t.Errorf("keys spread over %d instances, want 4: %v", len(used), used)
+	}
+
+	// 实例进入维护后，只有它的Key会迁移
+	a.infos["10.0.0.2"].State = StaCLOSE
+	a.rebuild()
+	for _, key := range keys {
+		adr := a.pick(BalanceHash, key)
+		if adr == "10.0.0.2" {
+			t.Errorf("key %s picked closed instance", key)
+		} else if owner[key] != "10.0.0.2" && adr != owner[key] {
+			t.Errorf("key %s moved from %s to %s", key, owner[key], adr)
+		}
+	}
+}
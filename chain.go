@@ -2,6 +2,7 @@ package micro
 
 import (
 	"net"
+	"time"
 
 	"github.com/micro/packet"
 )
@@ -12,7 +13,9 @@ type chain interface {
 	Handle(net.Conn, string, *packet.Packet) bool
 	SendData(interface{}, string, []string)
 	SendGroup(interface{}, string, uint8, string)
+	Kick(string, int, string) bool
 	Reload()
+	Drain(time.Time)
 	Close()
 }
 
@@ -24,5 +27,7 @@ func (c *baseChain) Handle(conn net.Conn, upgrade string, pack *packet.Packet) b
 }
 func (c *baseChain) SendData(data interface{}, api string, uids []string)             {}
 func (c *baseChain) SendGroup(data interface{}, api string, flag uint8, group string) {}
+func (c *baseChain) Kick(uid string, code int, reason string) bool                    { return false }
 func (c *baseChain) Reload()                                                          {}
+func (c *baseChain) Drain(deadline time.Time)                                         {}
 func (c *baseChain) Close()                                                           {}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"net"
 	"runtime"
+	"sync/atomic"
 	"time"
 
 	"github.com/micro/packet"
@@ -36,6 +37,58 @@ func (c *closer) Handle(conn net.Conn, name string, pack *packet.Packet) bool {
 	return true
 }
 
+// ServerClosingAPI 服务器关闭时推送给客户端的消息名称
+const ServerClosingAPI = `serverClosing`
+
+// drainService 平滑关闭服务
+// 通知客户端，等待进行中的业务处理完成，再断开所有连接
+func drainService() {
+	atomic.StoreInt32(&env.closing, 1)
+	env.registry.SetState(StaCLOSE)
+	deadline := time.Now().Add(time.Duration(env.config.DrainTimeout) * time.Second)
+
+	// 通知本实例的客户端服务器即将关闭(不转发到其他实例)
+	sendDataLocal(serverClosingError, ServerClosingAPI, nil)
+
+	// 等待业务处理完成
+	if !waitUntil(deadline, func() bool { return atomic.LoadInt64(&env.calls) <= 0 }) {
+		Logf("drain timeout, %d calls still running", atomic.LoadInt64(&env.calls))
+	}
+
+	// 断开连接
+	for i := 0; i < len(env.chains); i++ {
+		env.chains[i].Drain(deadline)
+	}
+}
+
+// beginCall 开始处理业务，服务关闭中时返回false
+func beginCall() bool {
+	atomic.AddInt64(&env.calls, 1)
+	if atomic.LoadInt32(&env.closing) == 1 {
+		atomic.AddInt64(&env.calls, -1)
+		return false
+	}
+	return true
+}
+
+// endCall 业务处理完成
+func endCall() {
+	atomic.AddInt64(&env.calls, -1)
+}
+
+// waitUntil 等待条件成立或超时
+func waitUntil(deadline time.Time, done func() bool) bool {
+	const INTERVAL = time.Millisecond * 50
+
+	for !done() {
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		time.Sleep(INTERVAL)
+	}
+	return true
+}
+
 // RegisterReloadFunc 注册服务器关闭接口
 func RegisterCloseFunc(f func()) {
 	env.closeFunc = append(env.closeFunc, func() {
@@ -57,7 +110,11 @@ func RegisterCloseFunc(f func()) {
 func requestCloseService() error {
 	const TIMEOUT = time.Second * 3
 
-	conn, err := net.DialTimeout("tcp", localeAddress(), time.Second)
+	address := localeAddress()
+	if err := env.tls.Init(); err != nil {
+		return err
+	}
+	conn, err := env.tls.Dial(address, time.Second)
 	if err != nil {
 		return errors.New("service not found, it may be closed")
 	}
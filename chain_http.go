@@ -1,10 +1,10 @@
 package micro
 
 import (
-	"bytes"
 	"encoding/xml"
 	"io/ioutil"
 	"net"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -66,17 +66,32 @@ func (h *http) Handle(conn net.Conn, name string, pack *packet.Packet) bool {
 	)
 
 	// 获取远端地址
-	remote = pack.HTTPHeaderValue(httpRemoteAddress)
-	if remote == "" {
-		remote = conn.RemoteAddr().String()
-	}
+	remote = clientRemote(conn, pack.HTTPHeaderValue(httpRemoteAddress))
 
+	var req packet.HTTPRequest
 	for {
+		// 解析报头
+		if err := pack.ParseHTTPRequest(&req); err != nil {
+			break
+		}
+
 		// 是否关闭连接
-		isClosed := pack.Index(httpConnectionClose) >= 0
+		isClosed := !req.KeepAlive()
 
-		if pack.HasPrefix(httpOption) {
+		if req.Invalid {
+			// 非法路径，不路由也不读取静态资源
+			pack.Reset()
+			pack.Write(httpRespBad400)
+			pack.Write(httpRespContent0)
+			pack.Write(httpConnectionClose)
+			pack.Write(httpRowAt)
+			pack.FlushToConn(conn)
+			break
+		}
+
+		if req.Method == "OPTIONS" {
 			// 处理Option请求
+			pack.ReadHTTPStream(conn)
 			pack.Reset()
 			pack.Write(httpRespOkAccess)
 			pack.Write(httpRespContent0)
@@ -86,10 +101,9 @@ func (h *http) Handle(conn net.Conn, name string, pack *packet.Packet) bool {
 			}
 		} else {
 			// 是否支持zlib
-			v := pack.DataBetween(httpAcceptEncoding, httpRowAt)
-			isZlib := bytes.Index(v, httpAcceptZib) >= 0
+			isZlib := strings.Contains(req.Header(httpHeaderAcceptEncoding), string(httpAcceptZib))
 			// api
-			api := pack.HTTPHeaderValue(httpAPI)
+			api := req.Header(httpHeaderAPI)
 			if api != "" {
 				// 处理API
 				if cac == nil {
@@ -98,25 +112,26 @@ func (h *http) Handle(conn net.Conn, name string, pack *packet.Packet) bool {
 				if err := h.callAPI(conn, pack, api, remote, isZlib, cac, isClosed); err != nil {
 					break
 				}
+			} else if b, params, ok := findRoute(req.Method, req.Path); ok {
+				// 处理路由
+				if err := h.processThirdPartRequest(conn, pack, &req, b, params, remote, isClosed); err != nil {
+					break
+				}
+			} else if strings.HasPrefix(req.Path, thirdPartPrefix) {
+				// 处理第三方调用
+				b := env.bis[req.Path[len(thirdPartPrefix):]]
+				if err := h.processThirdPartRequest(conn, pack, &req, b, nil, remote, isClosed); err != nil {
+					break
+				}
+			} else if env.config.MetricsPath != "" && req.Path == env.config.MetricsPath {
+				// 处理监控数据
+				if err := h.sendMetrics(conn, pack, isClosed); err != nil {
+					break
+				}
 			} else {
-				// 获取资源路径
-				path := string(pack.DataBetween(httpPathStart, httpPathEnd))
-				if strings.HasPrefix(path, thirdPartPrefix) {
-					// 处理第三方调用
-					pdx := strings.IndexByte(path, '?')
-					if pdx < 0 {
-						api = path[len(thirdPartPrefix):]
-					} else {
-						api = path[len(thirdPartPrefix):pdx]
-					}
-					if err := h.processThirdPartRequest(conn, pack, api, remote, isClosed); err != nil {
-						break
-					}
-				} else {
-					// 处理静态资源
-					if err := h.sendResource(conn, pack, path, isZlib, isClosed); err != nil {
-						break
-					}
+				// 处理静态资源
+				if err := h.sendResource(conn, pack, req.Path, isZlib, isClosed); err != nil {
+					break
 				}
 			}
 		}
@@ -135,18 +150,18 @@ func (h *http) Handle(conn net.Conn, name string, pack *packet.Packet) bool {
 	return true
 }
 
-// processThirdPartRequest 处理第三方接入
-func (h *http) processThirdPartRequest(conn net.Conn, pack *packet.Packet, api, remote string, isClosed bool) error {
+// processThirdPartRequest 处理第三方接入(路由或third-part/前缀)
+func (h *http) processThirdPartRequest(conn net.Conn, pack *packet.Packet, req *packet.HTTPRequest, b *bisAPI, params map[string]string, remote string, isClosed bool) error {
 	var (
 		resp interface{}
 		typ  string
 	)
 
-	bis, ok := findBis(api)
-	if !ok {
+	if b == nil {
 		// not found api
+		pack.ReadHTTPStream(conn)
 		pack.Reset()
-		pack.Write(httpRespOk)
+		pack.Write(httpRespOk404)
 		if isClosed {
 			pack.Write(httpConnectionClose)
 		}
@@ -157,17 +172,39 @@ func (h *http) processThirdPartRequest(conn net.Conn, pack *packet.Packet, api,
 	}
 
 	dpo := h.createThirdPartDpo()
-	// 读取消息体
-	pack.ReadHTTPBody(conn)
+	// 读取消息体(保留原始数据，便于签名校验)
+	pack.ReadHTTPStream(conn)
 	dpo.pack = pack
+	dpo.req = req
+	dpo.params = params
 	// 设置远端数据
 	dpo.SetRemote(remote)
-	resp, typ = bis(dpo)
+	if beginCall() {
+		resp, typ = b.call(dpo)
+		endCall()
+	} else {
+		// 服务关闭中，第三方稍后重试
+		resp = HTTPStatus(503)
+	}
 	h.freeThirdPartDpo(dpo)
 
 	// 设置响应数据
 	pack.Reset()
+	if status, ok := resp.(HTTPStatus); ok {
+		// 只有状态码的响应
+		pack.Write(status.statusLine())
+		if isClosed {
+			pack.Write(httpConnectionClose)
+		}
+		pack.Write(httpRespContent0)
+		pack.Write(httpRowAt)
+		_, err := pack.FlushToConn(conn)
+		return err
+	}
 	pack.Write(httpRespOk)
+	if isClosed {
+		pack.Write(httpConnectionClose)
+	}
 
 	switch typ {
 	default:
@@ -223,37 +260,97 @@ func (h *http) callAPI(conn net.Conn, pack *packet.Packet, api, remote string, i
 	var (
 		resp    interface{}
 		errCode string
+		start   = time.Now()
 	)
 
-	// 用户标识
-	uid := pack.HTTPHeaderValue(httpUID)
+	// 用户标识，有会话时使用会话中的用户及缓存
+	var (
+		uid   string
+		login string
+		ses   *session
+	)
+	if !env.config.HTTPSession {
+		// UID头域由客户端提供，未经校验，任何客户端都可以冒充其他用户
+		uid = pack.HTTPHeaderValue(httpUID)
+	}
+	if sid := pack.HTTPHeaderValue(httpSession); sid != "" {
+		sc := createDpoCache()
+		if s, ok := env.sessions.Load(sid, sc); ok {
+			ses, uid, cac = s, s.UID, sc
+		} else {
+			freeDpoCache(sc)
+		}
+	}
 
-	// 读取消息体
-	pack.ReadHTTPBody(conn)
+	// 读取消息体(二进制模式不做URL解码)
+	isBinary := strings.HasPrefix(pack.HTTPHeaderValue(httpContentType), httpBinaryType)
+	if isBinary {
+		pack.ReadHTTPStream(conn)
+	} else {
+		pack.ReadHTTPBody(conn)
+	}
 
 	// 调用业务接口
-	if !env.authorize.CheckAPI(uid, api) {
+	// 未经会话验证的UID头可以伪造，此时只按远端地址限流
+	limitUID := ""
+	if ses != nil {
+		limitUID = uid
+	}
+	if !env.limiter.Allow(remoteHost(remote), limitUID, api) {
+		errCode = rateLimitedError.ErrCode
+	} else if !env.authorize.CheckAPI(uid, api) {
 		errCode = noLoginError.ErrCode
 	} else {
 		if bis, ok := findBis(api); !ok {
 			errCode = apiNotFoundError.ErrCode
+		} else if !env.authorize.CheckRoles(api, rolesOf(cac)) {
+			errCode = forbiddenError.ErrCode
+		} else if !beginCall() {
+			errCode = serverClosingError.ErrCode
 		} else {
 			dpo := h.createDpo()
 			dpo.uid = uid
 			dpo.pack = pack
+			dpo.binary = isBinary
 			dpo.cache = cac
 			dpo.SetRemote(remote)
 			resp, errCode = bis(dpo)
+			login = dpo.uid
 			h.freeDpo(dpo)
+			endCall()
 		}
 	}
+	env.metrics.Observe(metricsHTTP, api, errCode, time.Since(start))
+
+	// 登入后创建新的会话，否则刷新会话
+	var sid string
+	if errCode == "" && login != "" && login != uid {
+		if ses != nil {
+			env.sessions.Del(ses.ID)
+		}
+		sid = env.sessions.Create(login, cac).ID
+	} else if ses != nil {
+		env.sessions.Save(ses)
+	}
+	if ses != nil {
+		freeDpoCache(ses.cache)
+	}
 
 	pack.Reset()
 	pack.Write(httpRespOkAccess)
-	pack.Write(httpRespJSON)
+	if isBinary {
+		pack.Write(httpRespBinary)
+	} else {
+		pack.Write(httpRespJSON)
+	}
 	pack.Write(httpAPI)
 	pack.Write(xutils.UnsafeStringToBytes(api))
 	pack.Write(httpRowAt)
+	if sid != "" {
+		pack.Write(httpSession)
+		pack.Write(xutils.UnsafeStringToBytes(sid))
+		pack.Write(httpRowAt)
+	}
 	if isClosed {
 		pack.Write(httpConnectionClose)
 	}
@@ -261,9 +358,13 @@ func (h *http) callAPI(conn net.Conn, pack *packet.Packet, api, remote string, i
 	// 业务错误
 	if errCode != "" {
 		s := pack.Size()
-		pack.Write(httpRespErrorPrefix)
-		pack.Write(xutils.UnsafeStringToBytes(errCode))
-		pack.Write(httpRespErrorSuffix)
+		if isBinary {
+			encodeBinary(pack, &errBisResp{ErrCode: errCode})
+		} else {
+			pack.Write(httpRespErrorPrefix)
+			pack.Write(xutils.UnsafeStringToBytes(errCode))
+			pack.Write(httpRespErrorSuffix)
+		}
 		e := pack.Size()
 		pack.Write(httpContentLength)
 		pack.Write(xutils.ParseIntToBytes(int64(e - s)))
@@ -284,7 +385,12 @@ func (h *http) callAPI(conn net.Conn, pack *packet.Packet, api, remote string, i
 
 	// 返回业务数据
 	s := pack.Size()
-	ok, _ := pack.EncodeJSON(resp, isZlib, false)
+	ok := false
+	if isBinary {
+		encodeBinary(pack, resp)
+	} else {
+		ok, _ = pack.EncodeJSON(resp, isZlib, false)
+	}
 	e := pack.Size()
 	pack.Write(httpContentLength)
 	pack.Write(xutils.ParseIntToBytes(int64(e - s)))
@@ -298,6 +404,27 @@ func (h *http) callAPI(conn net.Conn, pack *packet.Packet, api, remote string, i
 	return err
 }
 
+// sendMetrics 发送监控数据
+func (h *http) sendMetrics(conn net.Conn, pack *packet.Packet, isClosed bool) error {
+	pack.ReadHTTPBody(conn)
+	pack.Reset()
+	pack.Write(httpRespOk)
+	pack.Write(httpRespMetrics)
+	if isClosed {
+		pack.Write(httpConnectionClose)
+	}
+	s := pack.Size()
+	env.metrics.Encode(pack)
+	e := pack.Size()
+	pack.Write(httpContentLength)
+	pack.Write(xutils.ParseIntToBytes(int64(e - s)))
+	pack.Write(httpRowAt)
+	pack.Write(httpRowAt)
+	pack.MoveToEnd(s, e)
+	_, err := pack.FlushToConn(conn)
+	return err
+}
+
 // sendResource 发送静态资源
 func (h *http) sendResource(conn net.Conn, pack *packet.Packet, path string, isZlib bool, isClosed bool) error {
 	const resource = `resource`
@@ -532,6 +659,7 @@ func (h *http) freeDpo(dpo *httpDpo) {
 		return
 	}
 	dpo.pack = nil
+	dpo.binary = false
 	dpo.release()
 	h.dpoPool.Put(dpo)
 }
@@ -539,7 +667,8 @@ func (h *http) freeDpo(dpo *httpDpo) {
 type httpDpo struct {
 	baseDpo
 
-	pack *packet.Packet
+	pack   *packet.Packet
+	binary bool
 }
 
 // Parse 获取客户端参数
@@ -547,7 +676,12 @@ func (h *httpDpo) Parse(v interface{}) {
 	if h.pack == nil {
 		return
 	}
-	err := h.pack.DecodeJSON(v)
+	var err error
+	if h.binary {
+		err = decodeBinary(h.pack, v)
+	} else {
+		err = h.pack.DecodeJSON(v)
+	}
 	if err != nil {
 		Debug("http dpo parse data error: %v", err)
 	}
@@ -564,6 +698,9 @@ func (h *http) freeThirdPartDpo(dpo *thirdHttpDpo) {
 		return
 	}
 	dpo.pack = nil
+	dpo.req = nil
+	dpo.params = nil
+	dpo.values = nil
 	dpo.release()
 	h.dpoThirdPartPool.Put(dpo)
 }
@@ -572,33 +709,104 @@ func (h *http) freeThirdPartDpo(dpo *thirdHttpDpo) {
 type thirdHttpDpo struct {
 	baseDpo
 
-	pack *packet.Packet
+	pack   *packet.Packet
+	req    *packet.HTTPRequest
+	params map[string]string
+	values url.Values
+}
+
+// Method 请求方法
+func (h *thirdHttpDpo) Method() string {
+	return h.req.Method
+}
+
+// Path 请求路径
+func (h *thirdHttpDpo) Path() string {
+	return h.req.Path
+}
+
+// Param 路由中的路径参数
+func (h *thirdHttpDpo) Param(name string) string {
+	return h.params[name]
+}
+
+// Query 查询参数
+func (h *thirdHttpDpo) Query(name string) string {
+	return h.req.QueryValues().Get(name)
+}
+
+// Header 请求头域
+func (h *thirdHttpDpo) Header(name string) string {
+	return h.req.Header(name)
+}
+
+// Body 原始消息体
+func (h *thirdHttpDpo) Body() []byte {
+	return h.pack.Data()
+}
+
+// Values 查询参数及表单消息体中的参数
+// 同名参数查询参数在前，用于签名校验时按名称排序拼接
+func (h *thirdHttpDpo) Values() url.Values {
+	if h.values != nil {
+		return h.values
+	}
+	h.values = make(url.Values, 16)
+	for k, vs := range h.req.QueryValues() {
+		h.values[k] = append(h.values[k], vs...)
+	}
+	if h.isForm() {
+		form, err := url.ParseQuery(string(h.pack.Data()))
+		if err != nil {
+			Debug("third-part dpo(form) parse data error: %v", err)
+		}
+		for k, vs := range form {
+			h.values[k] = append(h.values[k], vs...)
+		}
+	}
+	return h.values
+}
+
+// isForm 消息体是否为表单格式
+// 未指定Content-Type时，非JSON/XML的消息体按表单处理
+func (h *thirdHttpDpo) isForm() bool {
+	typ := h.req.Header(httpHeaderContentType)
+	if typ != "" {
+		return strings.HasPrefix(typ, httpFormType)
+	}
+	switch h.pack.At(0) {
+	case '{', '[', '<':
+		return false
+	}
+	return true
 }
 
 // Parse 获取客户端参数
+// JSON/XML消息体直接解码，否则将查询参数及表单参数解码到结构体
 func (h *thirdHttpDpo) Parse(v interface{}) {
 	if h.pack == nil {
 		return
 	}
-	switch h.pack.At(0) {
-	default:
+	if h.pack.Size() == 0 || h.isForm() {
 		// form
-		//v := reflect.ValueOf(v)
-		//for i := 0; i < v.NumField(); i++ {
-		//	f := v.Field(i)
-		//	f.Type().Name()
-		//}
-	case '{':
-		// json
-		err := h.pack.DecodeJSON(v)
+		err := decodeForm(h.Values(), v)
 		if err != nil {
-			Debug("third-part dpo(json) parse data error: %v", err)
+			Debug("third-part dpo(form) parse data error: %v", err)
 		}
+		return
+	}
+	switch h.pack.At(0) {
 	case '<':
 		// xml
 		err := xml.Unmarshal(h.pack.Data(), v)
 		if err != nil {
 			Debug("third-part dpo(xml) parse data error: %v", err)
 		}
+	default:
+		// json
+		err := h.pack.DecodeJSON(v)
+		if err != nil {
+			Debug("third-part dpo(json) parse data error: %v", err)
+		}
 	}
 }
@@ -4,7 +4,6 @@ import (
 	"net"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/micro/packet"
@@ -15,6 +14,11 @@ const (
 	registryBisInit   = 11
 	registryBisAdd    = 12
 	registryBisRemove = 13
+	registryBisUpdate = 14
+
+	// 实例上报websocket会话(启用ClusterPush时)
+	registryBisSession     = 15
+	registryBisSessionInit = 16
 )
 
 // registry 注册表
@@ -24,31 +28,142 @@ type registry struct {
 
 	running bool
 	client  net.Conn
+	current string
 	remotes []net.Conn
 
+	// 同步本节点注册信息的其他注册中心
+	peers []net.Conn
+
 	// 地址映射表
 	addresses map[string]*addr
+
+	// 负载均衡策略
+	balances map[string]uint8
+
+	// 直接注册到本节点的实例上报的会话(uid=>实例地址)
+	sessions map[string]string
+
+	// 本服务实例信息
+	local struct {
+		sync.Mutex
+		info ServerInfo
+	}
+
+	// 本实例的websocket会话，上线/下线由后台协程合并后批量上报
+	session struct {
+		sync.RWMutex
+		m map[string]struct{}
+		// 待上报的变化(uid=>是否在线)
+		pending map[string]bool
+		notify  chan struct{}
+	}
 }
 
 type addr struct {
-	found uint32
-	ads   []string
+	found   uint32
+	ads     []string
+	infos   map[string]*ServerInfo
+	origins map[string]string
+	total   uint32
+	ring    []ringNode
+}
+
+// ServerInfo 服务实例信息
+type ServerInfo struct {
+	Address string // 服务地址
+	Version string // 版本号
+	Zone    string // 所在区域
+	Weight  uint32 // 权重
+	Load    uint32 // 当前负载
+	Online  uint32 // 在线人数
+	State   byte   // 服务状态
+}
+
+// Encode 序列化(不包含地址)
+func (s *ServerInfo) Encode(p *packet.Packet) {
+	p.WriteString(s.Version)
+	p.WriteString(s.Zone)
+	p.WriteU32(s.Weight)
+	p.WriteU32(s.Load)
+	p.WriteU32(s.Online)
+	p.WriteByte(s.State)
+}
+
+// Decode 反序列化(不包含地址)
+func (s *ServerInfo) Decode(p *packet.Packet) {
+	s.Version = p.ReadString()
+	s.Zone = p.ReadString()
+	s.Weight = p.ReadU32()
+	s.Load = p.ReadU32()
+	s.Online = p.ReadU32()
+	s.State, _ = p.ReadByte()
 }
 
 // Init 初始化
 func (r *registry) Init() {
 	r.running = true
 	r.remotes = make([]net.Conn, 0, 16)
+	r.peers = make([]net.Conn, 0, 4)
 	r.addresses = make(map[string]*addr, 16)
-	if env.config.Registry != "" {
-		go r.Register(env.config.Registry)
+	r.sessions = make(map[string]string, 1024)
+	if r.balances == nil {
+		r.balances = make(map[string]uint8, 16)
+	}
+	r.local.Lock()
+	r.local.info.Version = env.config.Version
+	r.local.info.Zone = env.config.Zone
+	r.local.info.Weight = uint32(env.config.Weight)
+	r.local.Unlock()
+	r.session.Lock()
+	r.session.m = make(map[string]struct{}, 1024)
+	r.session.pending = make(map[string]bool, 64)
+	r.session.notify = make(chan struct{}, 1)
+	r.session.Unlock()
+	if env.config.ClusterPush {
+		go r.reportSessions()
+	}
+	if centers := registryCenters(); len(centers) > 0 {
+		go r.Register(centers)
+	}
+	for _, peer := range env.config.RegistryPeers {
+		go r.Replicate(peer)
+	}
+}
+
+// registryCenters 注册中心地址列表
+func registryCenters() []string {
+	var centers []string
+	for _, adr := range strings.Split(env.config.Registry, ",") {
+		if adr = strings.TrimSpace(adr); adr != "" {
+			centers = append(centers, adr)
+		}
 	}
+	return centers
+}
+
+// Centers 注册中心地址列表，当前连接的注册中心排在最前
+func (r *registry) Centers() []string {
+	centers := registryCenters()
+	r.local.Lock()
+	current := r.current
+	r.local.Unlock()
+	for i := 1; i < len(centers); i++ {
+		if centers[i] == current {
+			centers[0], centers[i] = centers[i], centers[0]
+			break
+		}
+	}
+	return centers
 }
 
 // Handle 处理Conn
 func (r *registry) Handle(conn net.Conn, name string, pack *packet.Packet) bool {
 	const TIMEOUT = time.Second * 10
 
+	if name == "registry-peer" {
+		r.handlePeer(conn, pack)
+		return true
+	}
 	if name != "registry" {
 		return false
 	}
@@ -76,6 +191,10 @@ func (r *registry) Handle(conn net.Conn, name string, pack *packet.Packet) bool
 		port := pack.HTTPHeaderValue(httpRegistryPort)
 		address = address[:hps+1] + port
 	}
+	info := ServerInfo{
+		Address: address,
+		Weight:  xutils.ParseU32(pack.HTTPHeaderValue(httpRegistryWeight), 1),
+	}
 
 	// 清除发送缓冲区
 	pack.ReadHTTPBody(conn)
@@ -84,44 +203,268 @@ func (r *registry) Handle(conn net.Conn, name string, pack *packet.Packet) bool
 	pack.SetTimeout(TIMEOUT, TIMEOUT)
 
 	// 广播加入事件
-	r.Broadcast(pack, registryBisAdd, conn, srvName, address)
+	r.Broadcast(pack, registryBisAdd, conn, srvName, &info)
 
-	// 保活连接
-	pack.ReadConnWithKeepAlive(conn)
+	// 保活连接，接收实例信息变更
+	for {
+		code, err := pack.ReadConnWithKeepAlive(conn)
+		if err != nil {
+			break
+		}
+		switch code {
+		case registryBisUpdate:
+			info.Decode(pack)
+			r.Broadcast(pack, registryBisUpdate, conn, srvName, &info)
+		case registryBisSession:
+			online := pack.ReadStrings()
+			r.setSessions(address, online, pack.ReadStrings())
+		case registryBisSessionInit:
+			r.resetSessions(address, pack.ReadStrings())
+		}
+	}
 
 	// 广播离开事件
-	r.Broadcast(pack, registryBisRemove, conn, srvName, address)
+	r.resetSessions(address, nil)
+	r.Broadcast(pack, registryBisRemove, conn, srvName, &info)
 
 	return true
 }
 
 // 获取服务地址
 func (r *registry) ServerAddress(name string) string {
+	return r.ServerAddressWithKey(name, "")
+}
+
+// ServerAddressWithKey 按负载均衡策略获取服务地址
+func (r *registry) ServerAddressWithKey(name, key string) string {
 	r.RLock()
 	as, ok := r.addresses[name]
-	if !ok {
+	if !ok || len(as.ads) == 0 {
 		r.RUnlock()
 		return ""
 	}
-	l := len(as.ads)
-	if l == 0 {
+	adr := as.pick(r.balances[name], key)
+	r.RUnlock()
+
+	return adr
+}
+
+// ServerInfos 获取服务的所有实例信息
+func (r *registry) ServerInfos(name string) []ServerInfo {
+	r.RLock()
+	as, ok := r.addresses[name]
+	if !ok {
 		r.RUnlock()
-		return ""
+		return nil
+	}
+	infos := make([]ServerInfo, 0, len(as.ads))
+	for _, adr := range as.ads {
+		if info, ok := as.infos[adr]; ok {
+			infos = append(infos, *info)
+		}
 	}
-	found := atomic.AddUint32(&as.found, 1)
-	adr := as.ads[found%uint32(l)]
 	r.RUnlock()
 
-	return adr
+	return infos
+}
+
+// SetState 设置本服务实例的状态
+func (r *registry) SetState(state byte) {
+	r.local.Lock()
+	if r.local.info.State != state {
+		r.local.info.State = state
+		r.publish()
+	}
+	r.local.Unlock()
+}
+
+// SetLoad 设置本服务实例的负载及在线人数
+func (r *registry) SetLoad(load, online uint32) {
+	r.local.Lock()
+	if r.local.info.Load != load || r.local.info.Online != online {
+		r.local.info.Load, r.local.info.Online = load, online
+		r.publish()
+	}
+	r.local.Unlock()
+}
+
+// publish 将本服务实例信息发送到注册中心
+// 调用前需持有local锁
+func (r *registry) publish() {
+	const TIMEOUT = time.Second * 3
+
+	if r.client == nil {
+		return
+	}
+	pack := packet.New(128)
+	pack.SetTimeout(TIMEOUT, TIMEOUT)
+	pack.BeginWrite()
+	pack.WriteU32(registryBisUpdate)
+	r.local.info.Encode(pack)
+	pack.EndWrite()
+	pack.FlushToConn(r.client)
+	packet.Free(pack)
+}
+
+// ReportSession 记录本实例会话的上线/下线，由后台协程异步上报到注册中心
+func (r *registry) ReportSession(uid string, online bool) {
+	r.session.Lock()
+	if online {
+		r.session.m[uid] = struct{}{}
+	} else {
+		delete(r.session.m, uid)
+	}
+	r.session.pending[uid] = online
+	r.session.Unlock()
+
+	select {
+	case r.session.notify <- struct{}{}:
+	default:
+	}
+}
+
+// reportSessions 合并一段时间内的会话变化，批量上报到注册中心
+// 未连接注册中心时丢弃变化，连接后由publishSessions发送全部会话
+func (r *registry) reportSessions() {
+	const (
+		TIMEOUT = time.Second * 3
+		BATCH   = time.Millisecond * 50
+	)
+
+	for range r.session.notify {
+		time.Sleep(BATCH)
+
+		r.session.Lock()
+		pending := r.session.pending
+		r.session.pending = make(map[string]bool, 64)
+		r.session.Unlock()
+
+		var online, offline []string
+		for uid, ok := range pending {
+			if ok {
+				online = append(online, uid)
+			} else {
+				offline = append(offline, uid)
+			}
+		}
+
+		r.local.Lock()
+		if r.client != nil {
+			pack := packet.New(128 + 16*len(pending))
+			pack.SetTimeout(TIMEOUT, TIMEOUT)
+			pack.BeginWrite()
+			pack.WriteU32(registryBisSession)
+			pack.WriteStrings(online)
+			pack.WriteStrings(offline)
+			pack.EndWrite()
+			pack.FlushToConn(r.client)
+			packet.Free(pack)
+		}
+		r.local.Unlock()
+	}
+}
+
+// HasSession 会话是否在本实例
+func (r *registry) HasSession(uid string) bool {
+	r.session.RLock()
+	_, ok := r.session.m[uid]
+	r.session.RUnlock()
+	return ok
+}
+
+// publishSessions 将本实例的全部会话发送到注册中心
+// 调用前需持有local锁
+func (r *registry) publishSessions() {
+	const TIMEOUT = time.Second * 3
+
+	if r.client == nil || !env.config.ClusterPush {
+		return
+	}
+	r.session.RLock()
+	uids := make([]string, 0, len(r.session.m))
+	for uid := range r.session.m {
+		uids = append(uids, uid)
+	}
+	r.session.RUnlock()
+	pack := packet.New(128 + 16*len(uids))
+	pack.SetTimeout(TIMEOUT, TIMEOUT)
+	pack.BeginWrite()
+	pack.WriteU32(registryBisSessionInit)
+	pack.WriteStrings(uids)
+	pack.EndWrite()
+	pack.FlushToConn(r.client)
+	packet.Free(pack)
+}
+
+// setSessions 记录实例上报的会话上线/下线
+func (r *registry) setSessions(address string, online, offline []string) {
+	r.Lock()
+	for _, uid := range online {
+		r.sessions[uid] = address
+	}
+	for _, uid := range offline {
+		if r.sessions[uid] == address {
+			delete(r.sessions, uid)
+		}
+	}
+	r.Unlock()
+}
+
+// resetSessions 替换实例上报的全部会话，uids为nil时仅移除
+func (r *registry) resetSessions(address string, uids []string) {
+	r.Lock()
+	for uid, adr := range r.sessions {
+		if adr == address {
+			delete(r.sessions, uid)
+		}
+	}
+	for _, uid := range uids {
+		r.sessions[uid] = address
+	}
+	r.Unlock()
+}
+
+// LocateSessions 查询会话所在的实例地址(地址=>uids)
+// uids为空时返回所有上报了会话的实例
+func (r *registry) LocateSessions(uids []string) map[string][]string {
+	located := make(map[string][]string, 4)
+	r.RLock()
+	if len(uids) == 0 {
+		for _, adr := range r.sessions {
+			if _, ok := located[adr]; !ok {
+				located[adr] = nil
+			}
+		}
+	} else {
+		for _, uid := range uids {
+			if adr, ok := r.sessions[uid]; ok {
+				located[adr] = append(located[adr], uid)
+			}
+		}
+	}
+	r.RUnlock()
+	return located
+}
+
+// SetBalance 设置负载均衡策略
+func (r *registry) SetBalance(name string, strategy uint8) {
+	r.Lock()
+	if r.balances == nil {
+		r.balances = make(map[string]uint8, 16)
+	}
+	r.balances[name] = strategy
+	r.Unlock()
 }
 
 // Close 关闭
 func (r *registry) Close() {
 	r.running = false
+	r.local.Lock()
 	if r.client != nil {
 		r.client.Close()
 		r.client = nil
 	}
+	r.local.Unlock()
 
 	r.Lock()
 	if len(r.remotes) > 0 {
@@ -130,24 +473,29 @@ func (r *registry) Close() {
 		}
 		r.remotes = r.remotes[:0]
 	}
+	for i := 0; i < len(r.peers); i++ {
+		r.peers[i].Close()
+	}
+	r.peers = r.peers[:0]
 	r.Unlock()
 }
 
 // Register 注册到指定位置
-func (r *registry) Register(remote string) {
+// 依次尝试各注册中心，连接断开后切换到下一个
+func (r *registry) Register(centers []string) {
 	const (
 		TIMEOUT  = time.Second * 30
 		ERRDELAY = time.Second * 3
 	)
 
-	var err error
-	for r.running {
-		r.client, err = net.DialTimeout("tcp", remote, TIMEOUT)
+	for i := 0; r.running; i++ {
+		remote := centers[i%len(centers)]
+		conn, err := env.tls.Dial(remote, TIMEOUT)
 		if err != nil {
+			Debug("register dial %s error %v", remote, err)
 			time.Sleep(ERRDELAY)
 			continue
 		}
-		conn := r.client
 
 		// 连接协商
 		pack := packet.New(512)
@@ -167,6 +515,10 @@ func (r *registry) Register(remote string) {
 		}
 		pack.Write(xutils.UnsafeStringToBytes(port))
 		pack.Write(httpRowAt)
+		// 服务权重
+		pack.Write(httpRegistryWeight)
+		pack.Write(xutils.ParseIntToBytes(int64(env.config.Weight)))
+		pack.Write(httpRowAt)
 		pack.Write(httpRowAt)
 
 		if _, err = pack.FlushToConn(conn); err != nil {
@@ -177,6 +529,11 @@ func (r *registry) Register(remote string) {
 			continue
 		}
 
+		r.local.Lock()
+		r.client = conn
+		r.current = remote
+		r.local.Unlock()
+
 		// 交换数据
 		var msgCode uint32
 		for {
@@ -189,8 +546,13 @@ func (r *registry) Register(remote string) {
 			case registryBisInit:
 				// 初始化
 				r.FillSet(pack)
-			case registryBisAdd:
-				// 添加name=address
+				// 发送本实例信息及会话
+				r.local.Lock()
+				r.publish()
+				r.publishSessions()
+				r.local.Unlock()
+			case registryBisAdd, registryBisUpdate:
+				// 添加/更新name=address
 				r.Add(pack)
 			case registryBisRemove:
 				// 移除name-address
@@ -198,6 +560,11 @@ func (r *registry) Register(remote string) {
 			}
 		}
 		packet.Free(pack)
+		r.local.Lock()
+		if r.client == conn {
+			r.client = nil
+		}
+		r.local.Unlock()
 		conn.Close()
 		time.Sleep(ERRDELAY)
 	}
@@ -220,29 +587,37 @@ func (r *registry) removeRemote(conn net.Conn) {
 }
 
 // Broadcast 广播事件
-func (r *registry) Broadcast(pack *packet.Packet, event uint32, conn net.Conn, name, address string) {
+// 事件同时同步到其他注册中心
+func (r *registry) Broadcast(pack *packet.Packet, event uint32, conn net.Conn, name string, info *ServerInfo) {
 	r.Lock()
 
-	// 加入/移除注册表信息
-	if r.addOrRemove(name, address, event) {
-		r.removeRemote(conn)
-	} else {
+	// 加入/更新/移除注册表信息
+	switch event {
+	case registryBisAdd:
+		r.add(name, info, "")
 		pack.BeginWrite()
 		pack.WriteU32(registryBisInit)
-		r.encode(pack)
+		r.encode(pack, false)
 		pack.EndWrite()
 		pack.FlushToConn(conn)
+	case registryBisUpdate:
+		r.add(name, info, "")
+	case registryBisRemove:
+		r.removeRemote(conn)
+		if !r.remove(name, info.Address, "") {
+			r.Unlock()
+			return
+		}
 	}
 
 	// 广播事件
-	pack.BeginWrite()
-	pack.WriteU32(event)
-	pack.WriteString(name)
-	pack.WriteString(address)
-	pack.EndWrite()
+	r.encodeEvent(pack, event, name, info)
 	for i := 0; i < len(r.remotes); i++ {
 		pack.FlushToConn(r.remotes[i])
 	}
+	for i := 0; i < len(r.peers); i++ {
+		pack.FlushToConn(r.peers[i])
+	}
 
 	// 注册连接
 	switch event {
@@ -253,17 +628,38 @@ func (r *registry) Broadcast(pack *packet.Packet, event uint32, conn net.Conn, n
 	r.Unlock()
 }
 
+// encodeEvent 序列化事件
+func (r *registry) encodeEvent(pack *packet.Packet, event uint32, name string, info *ServerInfo) {
+	pack.BeginWrite()
+	pack.WriteU32(event)
+	pack.WriteString(name)
+	pack.WriteString(info.Address)
+	if event != registryBisRemove {
+		info.Encode(pack)
+	}
+	pack.EndWrite()
+}
+
 // Encode 序列化
-func (r *registry) encode(pack *packet.Packet) {
+// localOnly 仅包含直接注册到本节点的服务
+func (r *registry) encode(pack *packet.Packet, localOnly bool) {
 	s := int(0)
 	for _, as := range r.addresses {
-		s += len(as.ads)
+		for _, a := range as.ads {
+			if !localOnly || as.origins[a] == "" {
+				s++
+			}
+		}
 	}
 	pack.WriteU32(uint32(s))
 	for name, as := range r.addresses {
 		for _, a := range as.ads {
+			if localOnly && as.origins[a] != "" {
+				continue
+			}
 			pack.WriteString(name)
 			pack.WriteString(a)
+			as.infos[a].Encode(pack)
 		}
 	}
 }
@@ -278,11 +674,13 @@ func (r *registry) FillSet(pack *packet.Packet) {
 	}
 
 	// fill
+	var info ServerInfo
 	s := pack.ReadU32()
 	for i := uint32(0); i < s; i++ {
 		name := pack.ReadString()
-		address := pack.ReadString()
-		r.add(name, address)
+		info.Address = pack.ReadString()
+		info.Decode(pack)
+		r.add(name, &info, "")
 	}
 	r.Unlock()
 }
@@ -292,51 +690,56 @@ func (r *registry) Remove(pack *packet.Packet) {
 	name := pack.ReadString()
 	address := pack.ReadString()
 	r.Lock()
-	r.remove(name, address)
+	r.remove(name, address, "")
 	r.Unlock()
 }
 
 // remove 移除name-address
-func (r *registry) remove(name, address string) {
+// origin 注册信息的来源，与记录的来源不一致时不移除
+func (r *registry) remove(name, address, origin string) bool {
 	as, ok := r.addresses[name]
 	if !ok {
-		return
+		return false
+	}
+	if o, ok := as.origins[address]; !ok || o != origin {
+		return false
 	}
 	as.ads = xutils.RemoveSS(as.ads, address)
+	delete(as.infos, address)
+	delete(as.origins, address)
+	as.rebuild()
+	return true
 }
 
-// Add 添加name-address
+// Add 添加/更新name-address
 func (r *registry) Add(pack *packet.Packet) {
+	var info ServerInfo
 	name := pack.ReadString()
-	address := pack.ReadString()
+	info.Address = pack.ReadString()
+	info.Decode(pack)
 	r.Lock()
-	r.add(name, address)
+	r.add(name, &info, "")
 	r.Unlock()
 }
 
-// add 添加name-address
-func (r *registry) add(name, address string) {
+// add 添加/更新name-address
+// origin 注册信息的来源，直接注册到本节点时为空，否则为同步来源的注册中心地址
+func (r *registry) add(name string, info *ServerInfo, origin string) {
 	if name == env.config.Name {
 		return
 	}
 	as, ok := r.addresses[name]
 	if !ok {
 		as = &addr{
-			ads: make([]string, 0, 16),
+			ads:     make([]string, 0, 16),
+			infos:   make(map[string]*ServerInfo, 16),
+			origins: make(map[string]string, 16),
 		}
 		r.addresses[name] = as
 	}
-	as.ads = xutils.AddNoRepeatItem(as.ads, address)
-}
-
-// addOrRemove 添加/移除注册表信息
-func (r *registry) addOrRemove(name, address string, event uint32) (isRemoved bool) {
-	switch event {
-	case registryBisAdd:
-		r.add(name, address)
-	case registryBisRemove:
-		isRemoved = true
-		r.remove(name, address)
-	}
-	return
+	as.ads = xutils.AddNoRepeatItem(as.ads, info.Address)
+	cpy := *info
+	as.infos[info.Address] = &cpy
+	as.origins[info.Address] = origin
+	as.rebuild()
 }
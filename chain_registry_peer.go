@@ -0,0 +1,176 @@
+package micro
+
+import (
+	"net"
+	"time"
+
+	"github.com/micro/packet"
+	"github.com/micro/xutils"
+)
+
+// handlePeer 处理其他注册中心的同步请求
+// 先发送直接注册到本节点的服务，之后持续推送变更事件
+func (r *registry) handlePeer(conn net.Conn, pack *packet.Packet) {
+	const TIMEOUT = time.Second * 10
+
+	if !r.running {
+		return
+	}
+	if _, ok := env.authorize.Check(pack.HTTPHeaderValue(httpAuthorize)); !ok {
+		return
+	}
+
+	// 清除发送缓冲区
+	pack.ReadHTTPBody(conn)
+	pack.SetTimeout(TIMEOUT, TIMEOUT)
+
+	// 发送本节点的注册表
+	r.Lock()
+	pack.BeginWrite()
+	pack.WriteU32(registryBisInit)
+	r.encode(pack, true)
+	pack.EndWrite()
+	if _, err := pack.FlushToConn(conn); err != nil {
+		r.Unlock()
+		return
+	}
+	r.peers = append(r.peers, conn)
+	r.Unlock()
+
+	// 保活连接
+	for {
+		if _, err := pack.ReadConnWithKeepAlive(conn); err != nil {
+			break
+		}
+	}
+
+	r.Lock()
+	for i := 0; i < len(r.peers); i++ {
+		if r.peers[i] == conn {
+			copy(r.peers[i:], r.peers[i+1:])
+			r.peers = r.peers[:len(r.peers)-1]
+			break
+		}
+	}
+	r.Unlock()
+}
+
+// Replicate 从其他注册中心同步注册表
+func (r *registry) Replicate(peer string) {
+	const (
+		TIMEOUT  = time.Second * 30
+		ERRDELAY = time.Second * 3
+	)
+
+	for r.running {
+		conn, err := env.tls.Dial(peer, TIMEOUT)
+		if err != nil {
+			time.Sleep(ERRDELAY)
+			continue
+		}
+
+		// 连接协商
+		pack := packet.New(512)
+		pack.SetTimeout(TIMEOUT, TIMEOUT)
+		pack.Write(httpRegistryPeer)
+		pack.Write(httpRowAt)
+		pack.Write(httpAuthorize)
+		pack.Write(xutils.UnsafeStringToBytes(env.authorize.NewCode(env.config.Name)))
+		pack.Write(httpRowAt)
+		pack.Write(httpRowAt)
+		if _, err = pack.FlushToConn(conn); err != nil {
+			Debug("registry peer handshake error %v", err)
+			packet.Free(pack)
+			conn.Close()
+			time.Sleep(ERRDELAY)
+			continue
+		}
+
+		// 交换数据
+		var msgCode uint32
+		for {
+			msgCode, err = pack.ReadConnWithKeepAlive(conn)
+			if err != nil {
+				Debug("registry peer read state error %v", err)
+				break
+			}
+			switch msgCode {
+			case registryBisInit:
+				r.replacePeer(pack, peer)
+			case registryBisAdd, registryBisUpdate, registryBisRemove:
+				r.applyPeer(pack, msgCode, peer)
+			}
+		}
+
+		// 移除该节点同步过来的注册信息
+		r.replacePeer(nil, peer)
+		packet.Free(pack)
+		conn.Close()
+		time.Sleep(ERRDELAY)
+	}
+}
+
+// replacePeer 用同步来的注册表替换该节点原有的注册信息
+// pack为nil时仅移除
+func (r *registry) replacePeer(pack *packet.Packet, peer string) {
+	evt := packet.New(256)
+	evt.SetTimeout(0, time.Second*3)
+
+	r.Lock()
+	var info ServerInfo
+	for name, as := range r.addresses {
+		for _, adr := range append([]string(nil), as.ads...) {
+			if as.origins[adr] != peer {
+				continue
+			}
+			info.Address = adr
+			r.remove(name, adr, peer)
+			r.flushEvent(evt, registryBisRemove, name, &info)
+		}
+	}
+	if pack != nil {
+		s := pack.ReadU32()
+		for i := uint32(0); i < s; i++ {
+			name := pack.ReadString()
+			info.Address = pack.ReadString()
+			info.Decode(pack)
+			r.add(name, &info, peer)
+			r.flushEvent(evt, registryBisAdd, name, &info)
+		}
+	}
+	r.Unlock()
+
+	packet.Free(evt)
+}
+
+// applyPeer 处理同步来的变更事件
+func (r *registry) applyPeer(pack *packet.Packet, event uint32, peer string) {
+	var info ServerInfo
+	name := pack.ReadString()
+	info.Address = pack.ReadString()
+	if event != registryBisRemove {
+		info.Decode(pack)
+	}
+
+	evt := packet.New(256)
+	evt.SetTimeout(0, time.Second*3)
+	r.Lock()
+	if event == registryBisRemove {
+		if r.remove(name, info.Address, peer) {
+			r.flushEvent(evt, event, name, &info)
+		}
+	} else {
+		r.add(name, &info, peer)
+		r.flushEvent(evt, event, name, &info)
+	}
+	r.Unlock()
+	packet.Free(evt)
+}
+
+// flushEvent 将事件发送给直接注册到本节点的服务
+func (r *registry) flushEvent(pack *packet.Packet, event uint32, name string, info *ServerInfo) {
+	r.encodeEvent(pack, event, name, info)
+	for i := 0; i < len(r.remotes); i++ {
+		pack.FlushToConn(r.remotes[i])
+	}
+}
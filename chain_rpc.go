@@ -1,6 +1,7 @@
 package micro
 
 import (
+	"context"
 	"errors"
 	"net"
 	"sync"
@@ -18,35 +19,66 @@ type rpc struct {
 	com         sync.RWMutex
 	cos         map[string]net.Conn
 	rem         sync.RWMutex
-	resp        map[uint64]chan *packet.Packet
+	resp        map[uint64]rpcResp
 	apiRwm      sync.RWMutex
 	apiRunning  bool
 	apiWorkers  [apiWorkerNum]chan *rpcApiWorker
 	apiWorkPool sync.Pool
 	dpoPool     sync.Pool
+	cam         sync.Mutex
+	cancels     map[rpcCallKey]context.CancelFunc
+	pem         sync.RWMutex
+	pending     map[string]*int64
+}
+
+// rpcResp 等待响应的调用，连接断开时收到nil
+type rpcResp struct {
+	conn net.Conn
+	c    chan *packet.Packet
+}
+
+// rpcCallKey 处理中的远端请求
+type rpcCallKey struct {
+	conn  net.Conn
+	msgID uint64
 }
 
 const (
-	rpcCodeRequest  = 11
-	rpcCodeResponse = 12
-	rpcCodeDataOK   = 21
-	rpcCodeDataERR  = 22
-	rpcCodeDataNil  = 23
+	rpcCodeRequest    = 11
+	rpcCodeResponse   = 12
+	rpcCodeRequestCtx = 13
+	rpcCodeCancel     = 14
+	rpcCodeDataOK     = 21
+	rpcCodeDataERR    = 22
+	rpcCodeDataNil    = 23
 )
 
 var (
 	errRPCTimeout = errors.New("rpc call timeout")
+	errRPCClosed  = errors.New("rpc connection closed")
 )
 
+// rpcRemoteError 远端业务处理返回的错误码
+type rpcRemoteError string
+
+func (e rpcRemoteError) Error() string {
+	return string(e)
+}
+
 // Init 初始化
 func (r *rpc) Init() {
 	r.cos = make(map[string]net.Conn, 16)
-	r.resp = make(map[uint64]chan *packet.Packet, 128)
+	r.resp = make(map[uint64]rpcResp, 128)
+	r.cancels = make(map[rpcCallKey]context.CancelFunc, 128)
+	r.pending = make(map[string]*int64, 16)
 	for i := 0; i < apiWorkerNum; i++ {
 		r.apiWorkers[i] = make(chan *rpcApiWorker, 128)
 		go func(c <-chan *rpcApiWorker) {
 			for worker := range c {
 				r.doWorker(worker)
+				if !worker.closing {
+					endCall()
+				}
 				r.freeApiWorker(worker)
 			}
 		}(r.apiWorkers[i])
@@ -109,24 +141,69 @@ func (r *rpc) Close() {
 
 // Call 远程调用
 func (r *rpc) Call(out, in interface{}, adr, api string) error {
-	const (
-		RT = time.Second * 3
-		WT = time.Second * 3
-	)
+	const RT = time.Second * 3
+
+	ctx, cancel := context.WithTimeout(context.Background(), RT)
+	err := r.call(ctx, out, in, adr, api, false)
+	cancel()
+	if err == context.DeadlineExceeded {
+		err = errRPCTimeout
+	}
+	return err
+}
+
+// CallContext 远程调用，剩余时间及取消信号随ctx传递到远端
+// ctx没有截止时间时使用默认的超时时长
+func (r *rpc) CallContext(ctx context.Context, out, in interface{}, adr, api string) error {
+	const RT = time.Second * 30
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, RT)
+		defer cancel()
+	}
+	return r.call(ctx, out, in, adr, api, true)
+}
+
+// call 远程调用
+func (r *rpc) call(ctx context.Context, out, in interface{}, adr, api string, withCtx bool) error {
+	const WT = time.Second * 3
 
 	// 获取连接
 	conn, err := r.createOrGetConn(adr)
 	if err != nil {
 		return err
 	}
-	
+
 	// 组装数据
+	wt := WT
+	remain, hasDeadline := time.Duration(0), false
+	if deadline, ok := ctx.Deadline(); ok {
+		remain, hasDeadline = time.Until(deadline), true
+		if remain <= 0 {
+			return context.DeadlineExceeded
+		}
+		if remain < wt {
+			wt = remain
+		}
+	}
 	pack := packet.New(1024)
-	pack.SetTimeout(RT, WT)
+	pack.SetTimeout(0, wt)
 	pack.BeginWrite()
 	msgID := atomic.AddUint64(&r.msgID, 1)
-	pack.WriteU32(rpcCodeRequest)
-	pack.WriteU64(msgID)
+	if withCtx {
+		pack.WriteU32(rpcCodeRequestCtx)
+		pack.WriteU64(msgID)
+		// 发送剩余时间而不是截止时刻，不受两端时钟偏差影响
+		if hasDeadline {
+			pack.WriteI64(int64(remain))
+		} else {
+			pack.WriteI64(0)
+		}
+	} else {
+		pack.WriteU32(rpcCodeRequest)
+		pack.WriteU64(msgID)
+	}
 	pack.WriteString(api)
 	if in != nil {
 		if i, ok := in.(packet.Encoder); ok {
@@ -140,19 +217,23 @@ func (r *rpc) Call(out, in interface{}, adr, api string) error {
 	// 注册接收器
 	resp := make(chan *packet.Packet, 1)
 	r.rem.Lock()
-	r.resp[msgID] = resp
+	r.resp[msgID] = rpcResp{conn: conn, c: resp}
 	r.rem.Unlock()
+	pending := r.pendingOf(adr)
+	atomic.AddInt64(pending, 1)
 
 	// 发送数据
 	_, err = pack.FlushToConn(conn)
-	packet.Free(pack)
 
 	// 接收数据
 	if err == nil {
-		t := time.NewTimer(RT)
 		select {
 		case rsp := <-resp:
-			t.Stop()
+			if rsp == nil {
+				// 连接已断开
+				err = errRPCClosed
+				break
+			}
 			switch rsp.ReadU32() {
 			case rpcCodeDataOK:
 				if out != nil {
@@ -163,16 +244,26 @@ func (r *rpc) Call(out, in interface{}, adr, api string) error {
 					}
 				}
 			case rpcCodeDataERR:
-				err = errors.New(rsp.ReadString())
+				err = rpcRemoteError(rsp.ReadString())
 			case rpcCodeDataNil:
 			}
 			packet.Free(rsp)
-		case <-t.C:
-			err = errRPCTimeout
+		case <-ctx.Done():
+			err = ctx.Err()
+			if withCtx {
+				// 通知远端取消处理
+				pack.BeginWrite()
+				pack.WriteU32(rpcCodeCancel)
+				pack.WriteU64(msgID)
+				pack.EndWrite()
+				pack.FlushToConn(conn)
+			}
 		}
 	}
+	packet.Free(pack)
 
 	// 清理资源
+	atomic.AddInt64(pending, -1)
 	r.rem.Lock()
 	delete(r.resp, msgID)
 	close(resp)
@@ -181,6 +272,35 @@ func (r *rpc) Call(out, in interface{}, adr, api string) error {
 	return err
 }
 
+// pendingOf 获取指定地址的未完成请求计数器
+func (r *rpc) pendingOf(adr string) *int64 {
+	r.pem.RLock()
+	n, ok := r.pending[adr]
+	r.pem.RUnlock()
+	if ok {
+		return n
+	}
+
+	r.pem.Lock()
+	if n, ok = r.pending[adr]; !ok {
+		n = new(int64)
+		r.pending[adr] = n
+	}
+	r.pem.Unlock()
+	return n
+}
+
+// Pending 指定地址上未完成的请求数量
+func (r *rpc) Pending(adr string) int64 {
+	r.pem.RLock()
+	n, ok := r.pending[adr]
+	r.pem.RUnlock()
+	if !ok {
+		return 0
+	}
+	return atomic.LoadInt64(n)
+}
+
 // createOrGetConn 获取连接
 func (r *rpc) createOrGetConn(adr string) (conn net.Conn, err error) {
 	const TIMEOUT = time.Second * 3
@@ -200,7 +320,7 @@ func (r *rpc) createOrGetConn(adr string) (conn net.Conn, err error) {
 		r.com.Unlock()
 		return
 	}
-	conn, err = net.DialTimeout("tcp", adr, TIMEOUT)
+	conn, err = env.tls.Dial(adr, TIMEOUT)
 	if err != nil {
 		r.com.Unlock()
 		return
@@ -270,47 +390,117 @@ func (r *rpc) receive(conn net.Conn) {
 			// response
 			r.rem.RLock()
 			msgID := pack.ReadU64()
-			if c, ok := r.resp[msgID]; ok {
+			if rc, ok := r.resp[msgID]; ok {
 				select {
-				case c <- pack.Copy():
+				case rc.c <- pack.Copy():
 				default:
 				}
 			}
 			r.rem.RUnlock()
-		case rpcCodeRequest:
+		case rpcCodeRequest, rpcCodeRequestCtx:
 			// request
 			r.apiRwm.RLock()
 			if r.apiRunning {
 				worker := r.createApiWorker()
+				worker.closing = !beginCall()
+				worker.msgID = pack.ReadU64()
+				if code == rpcCodeRequestCtx {
+					worker.ctx, worker.cancel = r.newCallContext(conn, worker.msgID, pack.ReadI64())
+				}
 				worker.pack = pack.Copy()
 				worker.pack.SetTimeout(RT, WT)
 				worker.conn = conn
 				r.addWorker(worker)
 			}
 			r.apiRwm.RUnlock()
+		case rpcCodeCancel:
+			// cancel
+			key := rpcCallKey{conn: conn, msgID: pack.ReadU64()}
+			r.cam.Lock()
+			if cancel, ok := r.cancels[key]; ok {
+				cancel()
+			}
+			r.cam.Unlock()
 		}
 	}
 	packet.Free(pack)
+
+	// 连接断开，等待该连接响应的调用立即失败
+	r.rem.RLock()
+	for _, rc := range r.resp {
+		if rc.conn == conn {
+			select {
+			case rc.c <- nil:
+			default:
+			}
+		}
+	}
+	r.rem.RUnlock()
+
+	// 连接断开，取消该连接上所有处理中的请求
+	r.cam.Lock()
+	for key, cancel := range r.cancels {
+		if key.conn == conn {
+			cancel()
+		}
+	}
+	r.cam.Unlock()
+}
+
+// newCallContext 创建远端请求的上下文
+// remain 调用方的剩余时间(纳秒)，0表示没有截止时间
+func (r *rpc) newCallContext(conn net.Conn, msgID uint64, remain int64) (context.Context, context.CancelFunc) {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if remain > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), time.Duration(remain))
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+
+	key := rpcCallKey{conn: conn, msgID: msgID}
+	r.cam.Lock()
+	r.cancels[key] = cancel
+	r.cam.Unlock()
+
+	return ctx, func() {
+		r.cam.Lock()
+		delete(r.cancels, key)
+		r.cam.Unlock()
+		cancel()
+	}
 }
 
 // doWorker 处理数据
 func (r *rpc) doWorker(worker *rpcApiWorker) {
+	const errCANCELED = `Canceled`
+
 	var (
 		resp    interface{}
 		errCode string
-		msgID   = worker.pack.ReadU64()
+		msgID   = worker.msgID
 		api     = worker.pack.ReadString()
+		start   = time.Now()
 	)
 
 	f, ok := findRps(api)
 	if !ok {
 		errCode = apiNotFoundError.ErrCode
+	} else if worker.closing {
+		errCode = serverClosingError.ErrCode
+	} else if worker.ctx != nil && worker.ctx.Err() != nil {
+		// 调用方已取消或超时
+		errCode = errCANCELED
 	} else {
 		dpo := r.createDpo()
 		dpo.pack = worker.pack
+		dpo.ctx = worker.ctx
 		resp, errCode = f(dpo)
 		r.freeDpo(dpo)
 	}
+	env.metrics.Observe(metricsRPC, api, errCode, time.Since(start))
 
 	// response
 	worker.pack.BeginWrite()
@@ -341,8 +531,13 @@ func (r *rpc) doWorker(worker *rpcApiWorker) {
 
 // rpcApiWorker rpc业务包
 type rpcApiWorker struct {
-	conn net.Conn
-	pack *packet.Packet
+	conn   net.Conn
+	pack   *packet.Packet
+	msgID  uint64
+	ctx    context.Context
+	cancel context.CancelFunc
+	// 服务关闭中，不处理业务直接应答错误(不计入处理中的业务)
+	closing bool
 }
 
 // createApiWorker 创建业务包
@@ -355,8 +550,13 @@ func (r *rpc) freeApiWorker(worker *rpcApiWorker) {
 	if worker == nil {
 		return
 	}
+	if worker.cancel != nil {
+		worker.cancel()
+	}
 	packet.Free(worker.pack)
 	worker.conn, worker.pack = nil, nil
+	worker.msgID, worker.ctx, worker.cancel = 0, nil, nil
+	worker.closing = false
 	r.apiWorkPool.Put(worker)
 }
 
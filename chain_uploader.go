@@ -91,9 +91,23 @@ func RegisterUploadFunc(name string, f uploadFunc) {
 	}
 }
 
+// uploadKeyPrefix 上传时指定HMAC签名密钥的前缀，其余视为通信掩码
+const uploadKeyPrefix = "hmac:"
+
 // RequestUploadService 上传文件
+// mask为通信掩码；服务使用HMAC签名时传入带前缀的签名密钥(hmac:kid:secret)
+// 当前目录的config.json配置了TLS证书时使用双向TLS连接
 func RequestUploadService(remoteAddress, mask string, apiAndFiles []string) {
-	env.authorize.Init(mask)
+	loadConfig()
+	if err := env.tls.Init(); err != nil {
+		Log(err)
+		return
+	}
+	if strings.HasPrefix(mask, uploadKeyPrefix) {
+		env.authorize.Init("", []string{mask[len(uploadKeyPrefix):]}, false, 0)
+	} else {
+		env.authorize.Init(mask, nil, true, 0)
+	}
 	for i := 1; i < len(apiAndFiles); i += 2 {
 		err := requestUploadService(apiAndFiles[i-1], apiAndFiles[i], remoteAddress)
 		Log(err)
@@ -113,7 +127,7 @@ func requestUploadService(api, fileName, remoteAddress string) error {
 		path = remoteAddress[i:]
 		remoteAddress = remoteAddress[:i]
 	}
-	conn, err := net.DialTimeout("tcp", remoteAddress, time.Second*3)
+	conn, err := env.tls.Dial(remoteAddress, time.Second*3)
 	if err != nil {
 		fd.Close()
 		return errors.New("service not found, it may be closed")
@@ -8,6 +8,7 @@ import (
 	"math"
 	"net"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -61,6 +62,37 @@ const (
 	workerSize = 16
 )
 
+// 数据编码方式(握手时由Sec-WebSocket-Protocol首项协商)
+const (
+	// wsModeJSON 文本帧 api{json}
+	wsModeJSON = 0
+	// wsModeCompress 文本帧，压缩的api{json}
+	wsModeCompress = 1
+	// wsModeBinary 二进制帧，api + packet.Encoder编码的数据
+	wsModeBinary = 2
+
+	wsModeCount = 3
+)
+
+// websocket关闭码(4000-4999由应用自定义)
+const (
+	// CloseNormal 正常关闭
+	CloseNormal = 1000
+	// CloseGoingAway 服务关闭
+	CloseGoingAway = 1001
+	// CloseProtocolError 协议错误
+	CloseProtocolError = 1002
+	// CloseTooLarge 消息过大
+	CloseTooLarge = 1009
+	// CloseKickedByRelogin 账号在其他地方登入
+	CloseKickedByRelogin = 4001
+	// CloseMaintenance 服务器维护
+	CloseMaintenance = 4002
+)
+
+// wsCloseTimeout 发送关闭帧后等待客户端响应的时间，超时直接断开
+const wsCloseTimeout = time.Second * 3
+
 type websocket struct {
 	baseChain
 
@@ -75,6 +107,15 @@ type websocket struct {
 		pool sync.Pool
 	}
 
+	// 可靠推送
+	push wsPushes
+
+	// 活跃连接(握手完成前为nil)
+	live struct {
+		sync.Mutex
+		m map[net.Conn]*wConn
+	}
+
 	// 数据发送器
 	sender struct {
 		sync.RWMutex
@@ -86,10 +127,20 @@ type websocket struct {
 }
 
 type wConn struct {
-	conn         net.Conn
-	uid          string
-	isCompressed bool
-	group        tUserDpoGroup
+	conn    net.Conn
+	uid     string
+	mode    uint8
+	deflate bool
+	// 发送协程，同一连接的数据由同一协程按顺序发送
+	sender uint32
+	// 解压器(协商了permessage-deflate时)
+	inflater *wsInflater
+	// 可靠推送，resume为握手时客户端已收到的推送序号
+	reliable bool
+	resume   uint64
+	// 已发送关闭帧
+	closing int32
+	group   tUserDpoGroup
 }
 
 // Init 初始化
@@ -97,6 +148,8 @@ func (w *websocket) Init() {
 	for i := 0; i < chunkSize; i++ {
 		w.session.chunks[i].m = make(map[string]*wConn, 256)
 	}
+	w.live.m = make(map[net.Conn]*wConn, 1024)
+	w.initPush()
 	w.dpoPool.New = func() interface{} {
 		return &wsDpo{}
 	}
@@ -111,15 +164,7 @@ func (w *websocket) Init() {
 	}
 	for i := 0; i < workerSize; i++ {
 		w.sender.wgo[i] = make(chan *wkConnData, 512)
-		go func(c <-chan *wkConnData) {
-			const WT = time.Second * 10
-
-			for wcd := range c {
-				wcd.ad.pack.SetTimeout(0, WT)
-				wcd.ad.pack.FlushToConn(wcd.conn)
-				w.freeConnData(wcd)
-			}
-		}(w.sender.wgo[i])
+		go w.sendLoop(w.sender.wgo[i])
 	}
 }
 
@@ -129,33 +174,40 @@ func (w *websocket) Handle(conn net.Conn, name string, pack *packet.Packet) bool
 		return false
 	}
 
+	// 记录活跃连接
+	w.live.Lock()
+	w.live.m[conn] = nil
+	w.live.Unlock()
+	defer func() {
+		w.live.Lock()
+		delete(w.live.m, conn)
+		w.live.Unlock()
+	}()
+
 	var (
-		wc          *wConn
-		cac         dpoCache
-		senderIndex uint32 = workerSize
+		wc  *wConn
+		cac dpoCache
 	)
 
 	// 获取远端地址
-	remote := pack.HTTPHeaderValue(httpRemoteAddress)
-	if remote == "" {
-		remote = conn.RemoteAddr().String()
-	}
+	remote := clientRemote(conn, pack.HTTPHeaderValue(httpRemoteAddress))
 
 	if env.onLogin != nil {
 		// 如果设置了登入函数，需要校验登入Token
 
 		// 处理握手数据
-		uid, isCompress, err := w.handshake(conn, pack)
+		uid, hs, roles, err := w.handshake(conn, pack)
 		if err != nil || uid == "" {
 			return true
 		}
-		cac := createDpoCache()
+		cac = createDpoCache()
+		if len(roles) > 0 {
+			cac[dpoRolesKey] = roles
+		}
 
 		// 将自身注册到会话中
-		wc = w.createWConn()
+		wc = w.createWConn(conn, hs)
 		wc.uid = uid
-		wc.conn = conn
-		wc.isCompressed = isCompress
 		if w.RegisterConn(wc) && env.onLogin != nil {
 			// 调用登入
 			dpo := w.createDpo()
@@ -171,23 +223,26 @@ func (w *websocket) Handle(conn net.Conn, name string, pack *packet.Packet) bool
 		var payload = make([]byte, 8)
 		for {
 			// 组装业务参数
-			err = w.decodeWebsocket(conn, pack, payload)
+			opCode, err := w.decodeWebsocket(wc, pack, payload)
 			if err != nil {
 				break
 			}
-			api := xutils.UnsafeBytesToString(pack.ReadWhen('{'))
+			msgMode := messageMode(wc.mode, opCode)
+			api := w.readAPI(pack, msgMode)
 			dpo := w.createDpo()
 			dpo.uid = uid
 			dpo.cache = cac
 			dpo.pack = pack
+			dpo.binary = msgMode == wsModeBinary
 			dpo.group = &wc.group
+			dpo.verified = true
 			dpo.SetRemote(remote)
 
 			// 调用业务接口
 			if resp := w.callAPI(dpo, api); resp != nil {
-				w.encodingResponseData(dpo.pack, api, resp, isCompress)
+				w.encodingResponseData(dpo.pack, api, resp, msgMode, wc.deflate)
 				ad := w.NewRespAutoData(dpo.pack.Copy())
-				senderIndex = w.AddRespConnData(conn, ad, senderIndex)
+				w.AddRespConnData(conn, ad, wc.sender)
 				w.freeAutoData(ad)
 			}
 			w.freeDpo(dpo)
@@ -196,34 +251,39 @@ func (w *websocket) Handle(conn net.Conn, name string, pack *packet.Packet) bool
 		// 不需要登入Token, 一般用于网页端的直接接入
 
 		// 处理握手数据
-		uid, isCompress, err := w.handshake(conn, pack)
+		uid, hs, _, err := w.handshake(conn, pack)
 		if err != nil {
 			return true
 		}
-		cac := createDpoCache()
-		wc = w.createWConn()
+		cac = createDpoCache()
+		wc = w.createWConn(conn, hs)
+		// 握手中的UID由客户端提供，业务接口绑定用户后才视为已确认
+		verified := false
 
 		// 处理数据
 		var payload = make([]byte, 8)
 		for {
 			// 组装业务参数
-			err = w.decodeWebsocket(conn, pack, payload)
+			opCode, err := w.decodeWebsocket(wc, pack, payload)
 			if err != nil {
 				break
 			}
-			api := xutils.UnsafeBytesToString(pack.ReadWhen('{'))
+			msgMode := messageMode(wc.mode, opCode)
+			api := w.readAPI(pack, msgMode)
 			dpo := w.createDpo()
 			dpo.uid = uid
 			dpo.cache = cac
 			dpo.pack = pack
+			dpo.binary = msgMode == wsModeBinary
 			dpo.group = &wc.group
+			dpo.verified = verified
 			dpo.SetRemote(remote)
 
 			// 校验登入状态
 			if !env.authorize.CheckAPI(dpo.uid, api) {
-				w.encodingResponseData(dpo.pack, api, apiNotFoundError, isCompress)
+				w.encodingResponseData(dpo.pack, api, apiNotFoundError, msgMode, wc.deflate)
 				ad := w.NewRespAutoData(dpo.pack.Copy())
-				senderIndex = w.AddRespConnData(conn, ad, senderIndex)
+				w.AddRespConnData(conn, ad, wc.sender)
 				w.freeAutoData(ad)
 			} else {
 				// 调用业务接口
@@ -231,21 +291,19 @@ func (w *websocket) Handle(conn net.Conn, name string, pack *packet.Packet) bool
 
 				// 将自身注册到会话中
 				if dpo.uid != "" && dpo.uid != uid {
-					uid = dpo.uid
+					uid, verified = dpo.uid, true
 					if wc.uid != "" {
 						w.UnRegisterConn(wc)
 					}
 					wc.uid = uid
-					wc.conn = conn
-					wc.isCompressed = isCompress
 					w.RegisterConn(wc)
 				}
 
 				// 发送响应数据
 				if resp != nil {
-					w.encodingResponseData(dpo.pack, api, resp, isCompress)
+					w.encodingResponseData(dpo.pack, api, resp, msgMode, wc.deflate)
 					ad := w.NewRespAutoData(dpo.pack.Copy())
-					senderIndex = w.AddRespConnData(conn, ad, senderIndex)
+					w.AddRespConnData(conn, ad, wc.sender)
 					w.freeAutoData(ad)
 				}
 			}
@@ -264,12 +322,42 @@ func (w *websocket) Handle(conn net.Conn, name string, pack *packet.Packet) bool
 		env.onLogout(dpo)
 		w.freeDpo(dpo)
 	}
+	w.live.Lock()
+	delete(w.live.m, conn)
+	w.live.Unlock()
 	w.freeWConn(wc)
 	freeDpoCache(cac)
 
 	return true
 }
 
+// Drain 通知客户端服务器维护并断开所有连接，等待登出处理完成
+func (w *websocket) Drain(deadline time.Time) {
+	var closing []wsClosing
+	w.live.Lock()
+	for conn, wc := range w.live.m {
+		if wc == nil {
+			conn.Close()
+		} else if cl, ok := wc.beginClose(); ok {
+			closing = append(closing, cl)
+		}
+	}
+	w.live.Unlock()
+	for _, cl := range closing {
+		w.closeConn(cl, CloseMaintenance, "server maintenance")
+	}
+
+	ok := waitUntil(deadline, func() bool {
+		w.live.Lock()
+		n := len(w.live.m)
+		w.live.Unlock()
+		return n == 0
+	})
+	if !ok {
+		Logf("drain timeout, websocket sessions still running")
+	}
+}
+
 // Close 关闭
 func (w *websocket) Close() {
 	w.sender.Lock()
@@ -281,13 +369,36 @@ func (w *websocket) Close() {
 
 // callAPI 调用业务接口
 func (w *websocket) callAPI(dpo *wsDpo, api string) interface{} {
+	// 请求过于频繁，未确认的UID可以伪造，此时只按远端地址限流
+	limitUID := ""
+	if dpo.verified {
+		limitUID = dpo.uid
+	}
+	if !env.limiter.Allow(dpo.Remote(), limitUID, api) {
+		env.metrics.Observe(metricsWebsocket, api, rateLimitedError.ErrCode, 0)
+		return rateLimitedError
+	}
+
 	// 没有发现业务接口
 	bis, ok := findBis(api)
 	if !ok {
+		env.metrics.Observe(metricsWebsocket, api, apiNotFoundError.ErrCode, 0)
 		return apiNotFoundError
 	}
 
+	// 没有访问权限
+	if !env.authorize.CheckRoles(api, rolesOf(dpo.cache)) {
+		env.metrics.Observe(metricsWebsocket, api, forbiddenError.ErrCode, 0)
+		return forbiddenError
+	}
+
+	if !beginCall() {
+		return serverClosingError
+	}
+	start := time.Now()
 	resp, errCode := bis(dpo)
+	env.metrics.Observe(metricsWebsocket, api, errCode, time.Since(start))
+	endCall()
 	// 业务发生错误
 	if errCode != "" {
 		return &errBisResp{ErrCode: errCode}
@@ -296,10 +407,32 @@ func (w *websocket) callAPI(dpo *wsDpo, api string) interface{} {
 	return resp
 }
 
+// wsHandshake 握手时协商的参数
+type wsHandshake struct {
+	mode    uint8
+	deflate wsDeflate
+	// 可靠推送，resume为客户端已收到的最后一条推送的序号
+	reliable bool
+	resume   uint64
+}
+
 // handshake 处理握手
-func (w *websocket) handshake(conn net.Conn, pack *packet.Packet) (uid string, isCompress bool, err error) {
+// Sec-WebSocket-Protocol: 编码方式, Token(或UID)[, 已收到的推送序号]
+// 带有推送序号时启用可靠推送，断线重连后补发未收到的推送
+// 通过Token登入时返回Token中的会话角色
+func (w *websocket) handshake(conn net.Conn, pack *packet.Packet) (uid string, hs wsHandshake, roles []string, err error) {
 	protocols := strings.Split(pack.HTTPHeaderValue(wsProtocol), ",")
-	isCompress = strings.TrimSpace(protocols[0]) == "compress"
+	switch strings.TrimSpace(protocols[0]) {
+	case "compress":
+		hs.mode = wsModeCompress
+	case "binary":
+		hs.mode = wsModeBinary
+	}
+	if len(protocols) > 2 {
+		if seq, e := strconv.ParseUint(strings.TrimSpace(protocols[2]), 10, 64); e == nil {
+			hs.reliable, hs.resume = true, seq
+		}
+	}
 	if env.onLogin != nil {
 		// 没有设置token
 		if len(protocols) < 2 {
@@ -307,18 +440,23 @@ func (w *websocket) handshake(conn net.Conn, pack *packet.Packet) (uid string, i
 			return
 		}
 		// 校对Token值
-		var ok bool
-		uid, ok = env.authorize.CheckToken(strings.TrimSpace(protocols[1]))
+		claims, ok := env.authorize.ParseToken(strings.TrimSpace(protocols[1]))
 		if !ok {
 			err = errWSInvalidToken
 			return
 		}
+		uid, roles = claims.UID, claims.Roles
 	} else {
 		if len(protocols) > 1 {
 			uid = strings.TrimSpace(protocols[1])
 		}
 	}
 
+	// 已压缩的数据不再协商permessage-deflate
+	if hs.mode != wsModeCompress {
+		hs.deflate = negotiateDeflate(pack.HTTPHeaderValue(wsExtensions))
+	}
+
 	secWK := pack.HTTPHeaderValue(wsKey)
 	rsh1 := sha1.Sum(xutils.UnsafeStringToBytes(secWK + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
 	secWK = base64.StdEncoding.EncodeToString(rsh1[:])
@@ -331,6 +469,11 @@ func (w *websocket) handshake(conn net.Conn, pack *packet.Packet) (uid string, i
 		pack.Write(xutils.UnsafeStringToBytes(protocols[0]))
 		pack.Write(httpRowAt)
 	}
+	if hs.deflate.enabled {
+		pack.Write(wsExtensions)
+		pack.Write(xutils.UnsafeStringToBytes(hs.deflate.response()))
+		pack.Write(httpRowAt)
+	}
 	// sec-websocket-accept
 	pack.Write(wsAccept)
 	pack.Write(xutils.UnsafeStringToBytes(secWK))
@@ -341,39 +484,78 @@ func (w *websocket) handshake(conn net.Conn, pack *packet.Packet) (uid string, i
 	return
 }
 
+// messageMode 消息的编码方式
+// 二进制帧按二进制方式解码，文本帧按握手时协商的文本方式解码
+func messageMode(mode uint8, opCode byte) uint8 {
+	if opCode == 2 {
+		return wsModeBinary
+	}
+	if mode == wsModeBinary {
+		return wsModeJSON
+	}
+	return mode
+}
+
+// readAPI 读取请求的业务接口名称
+func (w *websocket) readAPI(pack *packet.Packet, mode uint8) string {
+	if mode == wsModeBinary {
+		return pack.ReadString()
+	}
+	return xutils.UnsafeBytesToString(pack.ReadWhen('{'))
+}
+
 // encodingResponseData 将要发送的数据进行编码，使之适合websocket协议
-func (w *websocket) encodingResponseData(pack *packet.Packet, api string, v interface{}, isCompress bool) {
+// deflate为true时按permessage-deflate压缩，超过wsFragmentSize的消息拆分为多个帧
+func (w *websocket) encodingResponseData(pack *packet.Packet, api string, v interface{}, mode uint8, deflate bool) {
 	pack.Reset()
 	pack.Allocate(10)
-	pack.EncodeJSONApi(v, isCompress, isCompress, xutils.UnsafeStringToBytes(api))
+	opCode := byte(0x81)
+	if mode == wsModeBinary {
+		opCode = 0x82
+		pack.WriteString(api)
+		encodeBinary(pack, v)
+	} else {
+		isCompress := mode == wsModeCompress
+		pack.EncodeJSONApi(v, isCompress, isCompress, xutils.UnsafeStringToBytes(api))
+	}
+	if deflate && pack.Size()-10 >= wsDeflateMin {
+		deflateMessage(pack, 10)
+		opCode |= 0x40
+	}
 	size := pack.Size() - 10
+	if size > wsFragmentSize {
+		fragmentMessage(pack, 10, opCode)
+		return
+	}
 	prefix := pack.Slice(0, 10)
 
 	offset := 0
 	switch {
 	case size < 126:
 		offset = 8
-		prefix[offset] = 0x81
+		prefix[offset] = opCode
 		prefix[offset+1] = byte(size)
 	case size < math.MaxUint16:
 		offset = 6
-		prefix[offset] = 0x81
+		prefix[offset] = opCode
 		prefix[offset+1] = 126
 		binary.BigEndian.PutUint16(prefix[offset+2:], uint16(size))
 	default:
 		offset = 0
-		prefix[offset] = 0x81
+		prefix[offset] = opCode
 		prefix[offset+1] = 127
 		binary.BigEndian.PutUint64(prefix[offset+2:], uint64(size))
 	}
 	pack.Seek(offset, -1)
 }
 
-// decodeWebsocket 从流中读出数据
-func (w *websocket) decodeWebsocket(conn net.Conn, pack *packet.Packet, payload []byte) (err error) {
+// decodeWebsocket 从流中读出一条消息，返回消息的类型(1文本/2二进制)
+// 协商了permessage-deflate时解压设置了RSV1的消息
+func (w *websocket) decodeWebsocket(wc *wConn, pack *packet.Packet, payload []byte) (msgCode byte, err error) {
 	const RT = time.Minute
 
-	wClose := false
+	conn, inf := wc.conn, wc.inflater
+	wClose, compressed := false, false
 	pack.Reset()
 
 	for {
@@ -395,9 +577,22 @@ func (w *websocket) decodeWebsocket(conn net.Conn, pack *packet.Packet, payload
 		}
 		wClose = false
 		fin := payload[0]>>7 == 1
-		//rv1 := (payload[0]>>6)&1 == 1
+		rsv1 := (payload[0]>>6)&1 == 1
 		opCode := payload[0] & 0xf
 
+		// RSV1只能出现在消息的第一帧，且需协商permessage-deflate
+		if payload[0]&0x30 != 0 || (rsv1 && (inf == nil || (opCode != 1 && opCode != 2))) {
+			writeClose(conn, CloseProtocolError, "")
+			err = errWSProtocol
+			return
+		}
+		if rsv1 {
+			compressed = true
+		}
+		if opCode == 1 || opCode == 2 {
+			msgCode = opCode
+		}
+
 		// MASH/Size(7bits)
 		hasMask := payload[1]>>7 == 1
 		size := int(payload[1] & 0x7f)
@@ -436,8 +631,15 @@ func (w *websocket) decodeWebsocket(conn net.Conn, pack *packet.Packet, payload
 			}
 		}
 
-		// 关闭连接
+		// 关闭连接，未发送过关闭帧时回应客户端的关闭码
 		if opCode == 8 {
+			if atomic.LoadInt32(&wc.closing) == 0 {
+				code := CloseNormal
+				if size >= 2 {
+					code = int(binary.BigEndian.Uint16(pack.Slice(pack.Size()-size, -1)))
+				}
+				writeClose(conn, code, "")
+			}
 			err = io.EOF
 			return
 		}
@@ -460,13 +662,25 @@ func (w *websocket) decodeWebsocket(conn net.Conn, pack *packet.Packet, payload
 			break
 		}
 	}
+	if compressed {
+		if err = inf.Inflate(pack); err != nil {
+			code := CloseProtocolError
+			if err == errWSInflateLimit {
+				code = CloseTooLarge
+			}
+			writeClose(conn, code, "")
+		}
+	}
 	return
 }
 
 type wsDpo struct {
 	baseDpo
 
-	pack *packet.Packet
+	pack   *packet.Packet
+	binary bool
+	// UID经过Token校验或由业务接口绑定，未确认的UID不参与按UID的限流
+	verified bool
 }
 
 // Parse 获取客户端参数
@@ -474,7 +688,12 @@ func (w *wsDpo) Parse(v interface{}) {
 	if w.pack == nil {
 		return
 	}
-	err := w.pack.DecodeJSON(v)
+	var err error
+	if w.binary {
+		err = decodeBinary(w.pack, v)
+	} else {
+		err = w.pack.DecodeJSON(v)
+	}
 	if err != nil {
 		Debug("websocket dpo parse data error: %v", err)
 	}
@@ -488,14 +707,29 @@ func (w *websocket) createDpo() *wsDpo {
 // FreeDpo 释放处理对象
 func (w *websocket) freeDpo(dpo *wsDpo) {
 	dpo.pack = nil
+	dpo.binary = false
+	dpo.verified = false
 	dpo.group = nil
 	dpo.release()
 	w.dpoPool.Put(dpo)
 }
 
-// createWConn 创建Conn
-func (w *websocket) createWConn() *wConn {
-	return w.session.pool.Get().(*wConn)
+// createWConn 创建Conn并记录到活跃连接中
+func (w *websocket) createWConn(conn net.Conn, hs wsHandshake) *wConn {
+	c := w.session.pool.Get().(*wConn)
+	c.conn = conn
+	c.mode = hs.mode
+	c.deflate = hs.deflate.enabled
+	if hs.deflate.enabled {
+		c.inflater = &wsInflater{takeover: !hs.deflate.clientNoContext}
+	}
+	c.reliable, c.resume = hs.reliable, hs.resume
+	c.sender = atomic.AddUint32(&w.sender.seq, 1) % workerSize
+
+	w.live.Lock()
+	w.live.m[conn] = c
+	w.live.Unlock()
+	return c
 }
 
 // freeWConn 释放Conn
@@ -505,7 +739,11 @@ func (w *websocket) freeWConn(c *wConn) {
 	}
 	c.conn = nil
 	c.uid = ""
-	c.isCompressed = false
+	c.mode = wsModeJSON
+	c.deflate = false
+	c.inflater = nil
+	c.reliable, c.resume = false, 0
+	c.closing = 0
 	c.group.clear()
 	w.session.pool.Put(c)
 }
@@ -564,17 +802,32 @@ func (w *websocket) RegisterConn(c *wConn) (isNewConn bool) {
 	if c == nil {
 		return
 	}
+	var (
+		old     wsClosing
+		kickOld bool
+		replay  wsReplay
+	)
 	idx := xutils.HashCode32(c.uid) % chunkSize
 	w.session.chunks[idx].Lock()
 	if c1, ok := w.session.chunks[idx].m[c.uid]; ok {
 		if c1 != c {
-			c1.conn.Close()
+			old, kickOld = c1.beginClose()
 		}
 	} else {
 		isNewConn = true
 	}
 	w.session.chunks[idx].m[c.uid] = c
+	// 在会话锁内恢复推送，补发完成前新的推送只缓存，推送与补发的消息不会乱序
+	replay = w.attachPush(c)
 	w.session.chunks[idx].Unlock()
+	// 发送队列满时入队会阻塞，在锁外关闭旧连接及补发推送
+	if kickOld {
+		w.closeConn(old, CloseKickedByRelogin, "kicked by relogin")
+	}
+	w.replayPush(c, replay)
+	if env.config.ClusterPush {
+		env.registry.ReportSession(c.uid, true)
+	}
 	return
 }
 
@@ -589,108 +842,159 @@ func (w *websocket) UnRegisterConn(c *wConn) (isMine bool) {
 		isMine = true
 		delete(w.session.chunks[idx].m, c.uid)
 	}
+	w.detachPush(c)
 	w.session.chunks[idx].Unlock()
+	// 可靠推送的会话在推送缓存过期时才下线，保留期间其他实例的推送仍转发到本实例缓存
+	if isMine && !c.reliable && env.config.ClusterPush {
+		env.registry.ReportSession(c.uid, false)
+	}
 	return
 }
 
+// Kick 向用户的连接发送关闭帧并断开
+func (w *websocket) Kick(uid string, code int, reason string) (ok bool) {
+	idx := xutils.HashCode32(uid) % chunkSize
+	var (
+		cl   wsClosing
+		send bool
+	)
+	w.session.chunks[idx].RLock()
+	c, ok := w.session.chunks[idx].m[uid]
+	if ok {
+		cl, send = c.beginClose()
+	}
+	w.session.chunks[idx].RUnlock()
+	if send {
+		w.closeConn(cl, code, reason)
+	}
+	return
+}
+
+// hasConn 用户是否有活跃的连接
+func (w *websocket) hasConn(uid string) bool {
+	chunk := &w.session.chunks[xutils.HashCode32(uid)%chunkSize]
+	chunk.RLock()
+	_, ok := chunk.m[uid]
+	chunk.RUnlock()
+	return ok
+}
+
+// wsClosing 待发送关闭帧的连接
+// wConn断开后会被回收复用，在锁内复制连接信息，在锁外发送关闭帧(发送队列满时会阻塞)
+type wsClosing struct {
+	conn   net.Conn
+	sender uint32
+}
+
+// beginClose 标记连接已发送关闭帧，已标记过时返回false
+func (c *wConn) beginClose() (wsClosing, bool) {
+	if !atomic.CompareAndSwapInt32(&c.closing, 0, 1) {
+		return wsClosing{}, false
+	}
+	return wsClosing{conn: c.conn, sender: c.sender}, true
+}
+
+// closeConn 由连接的发送协程在已排队的数据之后发送关闭帧
+// 客户端未在wsCloseTimeout内回应关闭帧时直接断开
+func (w *websocket) closeConn(cl wsClosing, code int, reason string) {
+	pack := packet.New(128)
+	encodeCloseFrame(pack, code, reason)
+	ad := w.NewRespAutoData(pack)
+	w.AddRespConnData(cl.conn, ad, cl.sender)
+	w.freeAutoData(ad)
+
+	time.AfterFunc(wsCloseTimeout, func() {
+		cl.conn.Close()
+	})
+}
+
 // SendData 发送数据
 func (w *websocket) SendData(v interface{}, api string, uis []string) {
-	var gzd, nzd *wkAutoData
+	var ads [wsModeCount * 2]*wkAutoData
 
 	if len(uis) > 0 {
 		// 按用户发送
-		for i := 0; i < chunkSize; i++ {
-			w.session.chunks[i].RLock()
-			for _, uid := range uis {
-				if m, ok := w.session.chunks[i].m[uid]; ok {
-					if m.isCompressed {
-						if gzd == nil {
-							pack := packet.New(2048)
-							w.encodingResponseData(pack, api, v, true)
-							gzd = w.NewRespAutoData(pack)
-						}
-						w.AddRespConnData(m.conn, gzd, workerSize)
-					} else {
-						if nzd == nil {
-							pack := packet.New(2048)
-							w.encodingResponseData(pack, api, v, false)
-							nzd = w.NewRespAutoData(pack)
-						}
-						w.AddRespConnData(m.conn, nzd, workerSize)
-					}
-				}
+		for _, uid := range uis {
+			chunk := &w.session.chunks[xutils.HashCode32(uid)%chunkSize]
+			chunk.RLock()
+			if m, ok := chunk.m[uid]; ok {
+				w.addSessionData(&ads, m, v, api)
 			}
-			w.session.chunks[i].RUnlock()
+			chunk.RUnlock()
 		}
+		w.pushUIDs(v, api, uis)
 	} else {
 		// 全部发送
 		for i := 0; i < chunkSize; i++ {
 			w.session.chunks[i].RLock()
 			for _, m := range w.session.chunks[i].m {
-				if m.isCompressed {
-					if gzd == nil {
-						pack := packet.New(2048)
-						w.encodingResponseData(pack, api, v, true)
-						gzd = w.NewRespAutoData(pack)
-					}
-					w.AddRespConnData(m.conn, gzd, workerSize)
-				} else {
-					if nzd == nil {
-						pack := packet.New(2048)
-						w.encodingResponseData(pack, api, v, false)
-						nzd = w.NewRespAutoData(pack)
-					}
-					w.AddRespConnData(m.conn, nzd, workerSize)
-				}
+				w.addSessionData(&ads, m, v, api)
 			}
 			w.session.chunks[i].RUnlock()
 		}
+		w.pushMatch(v, api, 16, "", nil)
 	}
 
 	// 释放资源
-	if gzd != nil {
-		w.freeAutoData(gzd)
-	}
-	if nzd != nil {
-		w.freeAutoData(nzd)
-	}
+	w.freeSessionData(&ads)
 }
 
 // SendGroup 按组发送数据
 func (w *websocket) SendGroup(v interface{}, api string, flag uint8, group string) {
-	var gzd, nzd *wkAutoData
+	var (
+		ads      [wsModeCount * 2]*wkAutoData
+		reliable map[string]struct{}
+	)
 
-	// 按组发送数据
+	// 按组发送数据，可靠推送的连接在会话锁内匹配分组后由推送状态发送
 	for i := 0; i < chunkSize; i++ {
 		w.session.chunks[i].RLock()
 		for _, m := range w.session.chunks[i].m {
 			if !m.group.Match(flag, group) {
 				continue
 			}
-			if m.isCompressed {
-				if gzd == nil {
-					pack := packet.New(2048)
-					w.encodingResponseData(pack, api, v, true)
-					gzd = w.NewRespAutoData(pack)
+			if m.reliable {
+				if reliable == nil {
+					reliable = make(map[string]struct{}, 64)
 				}
-				w.AddRespConnData(m.conn, gzd, workerSize)
+				reliable[m.uid] = struct{}{}
 			} else {
-				if nzd == nil {
-					pack := packet.New(2048)
-					w.encodingResponseData(pack, api, v, false)
-					nzd = w.NewRespAutoData(pack)
-				}
-				w.AddRespConnData(m.conn, nzd, workerSize)
+				w.addSessionData(&ads, m, v, api)
 			}
 		}
 		w.session.chunks[i].RUnlock()
 	}
+	w.pushMatch(v, api, flag, group, reliable)
 
 	// 释放资源
-	if gzd != nil {
-		w.freeAutoData(gzd)
+	w.freeSessionData(&ads)
+}
+
+// addSessionData 按会话的编码方式发送数据，相同编码方式(及是否压缩)的数据只编码一次
+// 可靠推送的连接由推送状态发送
+func (w *websocket) addSessionData(ads *[wsModeCount * 2]*wkAutoData, m *wConn, v interface{}, api string) {
+	if m.reliable {
+		return
 	}
-	if nzd != nil {
-		w.freeAutoData(nzd)
+	idx := m.mode * 2
+	if m.deflate {
+		idx++
+	}
+	ad := ads[idx]
+	if ad == nil {
+		pack := packet.New(2048)
+		w.encodingResponseData(pack, api, v, m.mode, m.deflate)
+		ad = w.NewRespAutoData(pack)
+		ads[idx] = ad
+	}
+	w.AddRespConnData(m.conn, ad, m.sender)
+}
+
+// freeSessionData 释放按编码方式缓存的数据
+func (w *websocket) freeSessionData(ads *[wsModeCount * 2]*wkAutoData) {
+	for _, ad := range ads {
+		if ad != nil {
+			w.freeAutoData(ad)
+		}
 	}
 }
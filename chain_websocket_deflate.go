@@ -0,0 +1,144 @@
+package micro
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/micro/packet"
+)
+
+// permessage-deflate(RFC 7692)
+const (
+	// wsDeflateMin 小于该长度的消息不压缩
+	wsDeflateMin = 256
+	// wsDeflateWindow 解压上下文(滑动窗口)的大小
+	wsDeflateWindow = 32 << 10
+	// wsInflateLimit 解压后消息的最大长度
+	wsInflateLimit = 16 << 20
+)
+
+// wsDeflateTail 压缩数据块的结尾(发送时去除，接收时补上)
+var wsDeflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+// wsDeflate permessage-deflate协商结果
+// 服务端始终不保留压缩上下文(server_no_context_takeover)，广播数据只需压缩一次
+type wsDeflate struct {
+	enabled bool
+	// 客户端不保留压缩上下文，每条消息独立解压
+	clientNoContext bool
+}
+
+// negotiateDeflate 从Sec-WebSocket-Extensions中选择第一个可接受的permessage-deflate参数
+// 服务端压缩使用完整窗口，客户端要求server_max_window_bits小于15时不接受
+func negotiateDeflate(header string) (d wsDeflate) {
+	if !env.config.WSDeflate {
+		return
+	}
+next:
+	for _, offer := range strings.Split(header, ",") {
+		params := strings.Split(offer, ";")
+		if strings.TrimSpace(params[0]) != "permessage-deflate" {
+			continue
+		}
+		d = wsDeflate{enabled: true, clientNoContext: env.config.WSDeflateNoContext}
+		for _, p := range params[1:] {
+			name, value := strings.TrimSpace(p), ""
+			if i := strings.IndexByte(name, '='); i >= 0 {
+				name, value = strings.TrimSpace(name[:i]), strings.Trim(strings.TrimSpace(name[i+1:]), `"`)
+			}
+			switch name {
+			case "server_no_context_takeover", "client_max_window_bits":
+			case "client_no_context_takeover":
+				d.clientNoContext = true
+			case "server_max_window_bits":
+				if bits, err := strconv.Atoi(value); err != nil || bits != 15 {
+					d = wsDeflate{}
+					continue next
+				}
+			default:
+				d = wsDeflate{}
+				continue next
+			}
+		}
+		return
+	}
+	return wsDeflate{}
+}
+
+// response 握手响应中的扩展参数
+func (d wsDeflate) response() string {
+	if d.clientNoContext {
+		return "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+	}
+	return "permessage-deflate; server_no_context_takeover"
+}
+
+// wsDeflaters 压缩器池
+var wsDeflaters = sync.Pool{
+	New: func() interface{} {
+		fw, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return fw
+	},
+}
+
+// deflateMessage 压缩pack中s之后的数据
+func deflateMessage(pack *packet.Packet, s int) {
+	buf := packet.New(pack.Size())
+	fw := wsDeflaters.Get().(*flate.Writer)
+	fw.Reset(buf)
+	fw.Write(pack.Slice(s, -1))
+	fw.Flush()
+	wsDeflaters.Put(fw)
+
+	data := bytes.TrimSuffix(buf.Data(), wsDeflateTail)
+	pack.Seek(-1, s)
+	pack.Write(data)
+	packet.Free(buf)
+}
+
+// wsInflater 连接的解压器
+type wsInflater struct {
+	fr   io.ReadCloser
+	src  bytes.Reader
+	out  bytes.Buffer
+	dict []byte
+
+	// 保留解压上下文
+	takeover bool
+}
+
+// Inflate 解压pack中的消息
+func (f *wsInflater) Inflate(pack *packet.Packet) error {
+	pack.Write(wsDeflateTail)
+	f.src.Reset(pack.Data())
+	if f.fr == nil {
+		f.fr = flate.NewReaderDict(&f.src, f.dict)
+	} else {
+		f.fr.(flate.Resetter).Reset(&f.src, f.dict)
+	}
+
+	f.out.Reset()
+	n, err := f.out.ReadFrom(io.LimitReader(f.fr, wsInflateLimit+1))
+	// 消息没有结束块，读完数据后返回ErrUnexpectedEOF
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	if n > wsInflateLimit {
+		return errWSInflateLimit
+	}
+
+	data := f.out.Bytes()
+	if f.takeover {
+		f.dict = append(f.dict, data...)
+		if len(f.dict) > wsDeflateWindow {
+			f.dict = append(f.dict[:0], f.dict[len(f.dict)-wsDeflateWindow:]...)
+		}
+	}
+	pack.Reset()
+	pack.Write(data)
+	return nil
+}
@@ -0,0 +1,149 @@
+package micro
+
+import (
+	"bytes"
+	"compress/flate"
+	"strings"
+	"testing"
+
+	"github.com/micro/packet"
+)
+
+func TestNegotiateDeflate(t *testing.T) {
+	saved := env.config
+	defer func() { env.config = saved }()
+
+	tests := []struct {
+		header    string
+		disabled  bool
+		noContext bool
+		want      wsDeflate
+	}{
+		{"permessage-deflate", true, false, wsDeflate{}},
+		{"", false, false, wsDeflate{}},
+		{"x-webkit-deflate-frame", false, false, wsDeflate{}},
+		{"permessage-deflate", false, false, wsDeflate{enabled: true}},
+		{"permessage-deflate", false, true, wsDeflate{enabled: true, clientNoContext: true}},
+		{"permessage-deflate; client_max_window_bits", false, false, wsDeflate{enabled: true}},
+		{"permessage-deflate; server_no_context_takeover; client_no_context_takeover", false, false, wsDeflate{enabled: true, clientNoContext: true}},
+		{`permessage-deflate; server_max_window_bits="15"`, false, false, wsDeflate{enabled: true}},
+		{"permessage-deflate; server_max_window_bits=10", false, false, wsDeflate{}},
+		{"permessage-deflate; server_max_window_bits=10, permessage-deflate", false, false, wsDeflate{enabled: true}},
+		{"permessage-deflate; unknown, permessage-deflate; client_no_context_takeover", false, false, wsDeflate{enabled: true, clientNoContext: true}},
+		{"foo, permessage-deflate ; client_max_window_bits=12", false, false, wsDeflate{enabled: true}},
+	}
+	for _, tt := range tests {
+		env.config.WSDeflate = !tt.disabled
+		env.config.WSDeflateNoContext = tt.noContext
+		if got := negotiateDeflate(tt.header); got != tt.want {
+			t.Errorf("negotiateDeflate(%q) = %+v, want %+v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestDeflateMessage(t *testing.T) {
+	var f wsInflater
+	msgs := []string{
+		strings.Repeat("hello websocket ", 64),
+		strings.Repeat("hello websocket ", 64),
+		`{"api":"push","data":` + strings.Repeat(`"abc",`, 100) + `}`,
+	}
+	for i, msg := range msgs {
+		pack := packet.New(1024)
+		pack.Write([]byte("head"))
+		pack.Write([]byte(msg))
+		deflateMessage(pack, 4)
+		if string(pack.Slice(0, 4)) != "head" {
+			t.Fatalf("#%d: header changed: %q", i, pack.Slice(0, 4))
+		}
+		if pack.Size()-4 >= len(msg) || bytes.HasSuffix(pack.Data(), wsDeflateTail) {
+			t.Errorf("#%d: deflated %d bytes to %d", i, len(msg), pack.Size()-4)
+		}
+
+		// 服务端不保留压缩上下文，每条消息都能独立解压
+		in := packet.New(1024)
+		in.Write(pack.Slice(4, -1))
+		if err := f.Inflate(in); err != nil {
+			t.Fatalf("#%d: inflate: %v", i, err)
+		}
+		if string(in.Data()) != msg {
+			t.Errorf("#%d: inflate = %q, want %q", i, in.Data(), msg)
+		}
+		packet.Free(in)
+		packet.Free(pack)
+	}
+}
+
+func TestInflateContextTakeover(t *testing.T) {
+	msgs := []string{
+		strings.Repeat("client message ", 32),
+		strings.Repeat("client message ", 32),
+		"short",
+		strings.Repeat("client message ", 32) + "tail",
+	}
+
+	tests := []struct {
+		name     string
+		client   bool // 客户端保留压缩上下文
+		takeover bool // 服务端保留解压上下文
+		fail     int  // 预期解压失败的消息序号，-1为全部成功
+	}{
+		{"no context takeover", false, false, -1},
+		{"context takeover", true, true, -1},
+		{"context not negotiated", true, false, 1},
+	}
+	for _, tt := range tests {
+		// 模拟客户端压缩：保留上下文时共用一个压缩器
+		var buf bytes.Buffer
+		fw, _ := flate.NewWriter(&buf, flate.BestSpeed)
+		f := wsInflater{takeover: tt.takeover}
+		for i, msg := range msgs {
+			if !tt.client {
+				fw.Reset(&buf)
+			}
+			buf.Reset()
+			fw.Write([]byte(msg))
+			fw.Flush()
+
+			pack := packet.New(1024)
+			pack.Write(bytes.TrimSuffix(buf.Bytes(), wsDeflateTail))
+			err := f.Inflate(pack)
+			if i == tt.fail {
+				if err == nil && string(pack.Data()) == msg {
+					t.Errorf("%s #%d: inflated without the previous context", tt.name, i)
+				}
+				packet.Free(pack)
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s #%d: inflate: %v", tt.name, i, err)
+			}
+			if string(pack.Data()) != msg {
+				t.Errorf("%s #%d: inflate = %q, want %q", tt.name, i, pack.Data(), msg)
+			}
+			packet.Free(pack)
+		}
+		if !tt.takeover && len(f.dict) != 0 {
+			t.Errorf("%s: dict kept %d bytes", tt.name, len(f.dict))
+		}
+	}
+}
+
+func TestInflateDictWindow(t *testing.T) {
+	f := wsInflater{takeover: true}
+	msg := bytes.Repeat([]byte("0123456789abcdef"), wsDeflateWindow/16+16)
+	var buf bytes.Buffer
+	fw, _ := flate.NewWriter(&buf, flate.BestSpeed)
+	fw.Write(msg)
+	fw.Flush()
+
+	pack := packet.New(1024)
+	pack.Write(bytes.TrimSuffix(buf.Bytes(), wsDeflateTail))
+	if err := f.Inflate(pack); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.dict) != wsDeflateWindow || !bytes.Equal(f.dict, msg[len(msg)-wsDeflateWindow:]) {
+		t.Errorf("dict has %d bytes, want the last %d", len(f.dict), wsDeflateWindow)
+	}
+	packet.Free(pack)
+}
@@ -0,0 +1,314 @@
+package micro
+
+import (
+	"encoding/json"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/micro/packet"
+	"github.com/micro/xutils"
+)
+
+// 可靠推送
+// 握手时带有推送序号的连接，推送按用户分配递增的序号(接口名后附加"@序号")，
+// 并缓存最近的推送；断线后在保留时间内重连，补发序号大于客户端已收到序号的推送
+const (
+	// wsReplaySize 未配置WSReplaySize时每个用户缓存的推送数量
+	wsReplaySize = 128
+	// wsResumeGrace 未配置WSResumeGrace时断线后保留推送缓存的时间
+	wsResumeGrace = time.Minute
+	// wsPushSweep 清理过期推送缓存的间隔
+	wsPushSweep = time.Second * 10
+)
+
+// PushResyncAPI 可靠推送无法补发全部缺失的推送时发送给客户端的消息名称
+// 消息内容为PushResync，客户端应重新拉取完整的状态
+const PushResyncAPI = `pushResync`
+
+// PushResync 可靠推送的缺口，序号在(Resume, Next)之间的推送已丢失
+type PushResync struct {
+	Resume uint64 // 客户端已收到的序号
+	Next   uint64 // 后续推送(含补发)的起始序号
+}
+
+// wsPushMsg 缓存的推送
+type wsPushMsg struct {
+	seq  uint64
+	api  string
+	body *wsPushBody
+}
+
+// wsPushBody 推送的数据，每种编码方式只编码一次，由所有用户的发送及补发共享
+type wsPushBody struct {
+	v interface{}
+
+	jsonOnce sync.Once
+	json     []byte
+	binOnce  sync.Once
+	bin      []byte
+}
+
+// encoded 按连接的编码方式返回已编码的数据
+func (b *wsPushBody) encoded(mode uint8) interface{} {
+	if mode == wsModeBinary {
+		b.binOnce.Do(func() {
+			pack := packet.New(256)
+			encodeBinary(pack, b.v)
+			b.bin = append([]byte(nil), pack.Data()...)
+			packet.Free(pack)
+		})
+		return binaryEncoded(b.bin)
+	}
+	b.jsonOnce.Do(func() {
+		b.json, _ = json.Marshal(b.v)
+	})
+	return json.RawMessage(b.json)
+}
+
+// wsPush 用户的可靠推送状态
+type wsPush struct {
+	seq  uint64
+	msgs []wsPushMsg
+
+	// 当前连接，断开后为nil
+	conn *wConn
+	// 连接恢复后正在锁外补发，期间新的推送只缓存
+	replaying bool
+	// 断开的时间及断开时的分组
+	offline time.Time
+	group   tUserDpoGroup
+}
+
+// wsPushes 可靠推送状态(按用户分块)
+type wsPushes [chunkSize]struct {
+	sync.Mutex
+	m map[string]*wsPush
+}
+
+// initPush 初始化可靠推送状态
+func (w *websocket) initPush() {
+	for i := 0; i < chunkSize; i++ {
+		w.push[i].m = make(map[string]*wsPush, 64)
+	}
+	go func() {
+		for {
+			time.Sleep(wsPushSweep)
+			w.sweepPush()
+		}
+	}()
+}
+
+// replaySize 每个用户缓存的推送数量
+func replaySize() int {
+	if env.config.WSReplaySize > 0 {
+		return env.config.WSReplaySize
+	}
+	return wsReplaySize
+}
+
+// resumeGrace 断线后保留推送缓存的时间
+func resumeGrace() time.Duration {
+	if env.config.WSResumeGrace > 0 {
+		return time.Duration(env.config.WSResumeGrace) * time.Second
+	}
+	return wsResumeGrace
+}
+
+// wsReplay 连接恢复时待补发的推送
+// wConn断开后会被回收复用，在锁内编码并复制连接信息，在锁外入队(发送队列满时会阻塞)
+type wsReplay struct {
+	uid    string
+	conn   net.Conn
+	sender uint32
+	seq    uint64 // 已编码的最大序号
+	packs  []*packet.Packet
+}
+
+// attachPush 连接注册到会话时恢复推送，返回待补发的推送，由调用方在释放会话锁后调用replayPush
+// 缓存已过期或已丢弃部分推送时先发送PushResync；
+// 不使用可靠推送的连接登入时删除该用户的推送缓存
+func (w *websocket) attachPush(c *wConn) (r wsReplay) {
+	chunk := &w.push[xutils.HashCode32(c.uid)%chunkSize]
+	chunk.Lock()
+	p, ok := chunk.m[c.uid]
+	switch {
+	case !c.reliable:
+		if ok {
+			delete(chunk.m, c.uid)
+		}
+	case !ok || c.resume > p.seq:
+		// 没有缓存(首次连接或已过期)，序号从客户端已收到的序号继续
+		p = &wsPush{seq: c.resume, conn: c}
+		chunk.m[c.uid] = p
+		if c.resume > 0 {
+			r.packs = append(r.packs, w.resyncFrame(c, PushResync{Resume: c.resume, Next: c.resume + 1}))
+		}
+	default:
+		p.conn = c
+		// 缓存中最早的序号
+		next := p.seq + 1
+		if len(p.msgs) > 0 {
+			next = p.msgs[0].seq
+		}
+		if next > c.resume+1 {
+			r.packs = append(r.packs, w.resyncFrame(c, PushResync{Resume: c.resume, Next: next}))
+		}
+		for _, m := range p.msgs {
+			if m.seq > c.resume {
+				r.packs = append(r.packs, w.pushFrame(c, m))
+			}
+		}
+	}
+	if len(r.packs) > 0 {
+		// 补发完成前新的推送只缓存，由replayPush按序发送
+		p.replaying = true
+		r.uid, r.conn, r.sender, r.seq = c.uid, c.conn, c.sender, p.seq
+	}
+	chunk.Unlock()
+	return
+}
+
+// replayPush 在锁外发送补发的推送，再发送补发期间新增的推送，直到没有新的推送
+// c 只用于编码及判断连接是否已被替换，调用期间不会被回收
+func (w *websocket) replayPush(c *wConn, r wsReplay) {
+	chunk := &w.push[xutils.HashCode32(r.uid)%chunkSize]
+	for len(r.packs) > 0 {
+		for _, pack := range r.packs {
+			w.enqueueFrame(r.conn, r.sender, pack)
+		}
+		r.packs = r.packs[:0]
+
+		chunk.Lock()
+		p, ok := chunk.m[r.uid]
+		if !ok || p.conn != c {
+			// 已断开或已被新的连接替换，由新的连接补发
+			chunk.Unlock()
+			return
+		}
+		for _, m := range p.msgs {
+			if m.seq > r.seq {
+				r.packs = append(r.packs, w.pushFrame(c, m))
+			}
+		}
+		if len(r.packs) == 0 {
+			p.replaying = false
+		}
+		r.seq = p.seq
+		chunk.Unlock()
+	}
+}
+
+// detachPush 连接断开，推送缓存保留到过期
+func (w *websocket) detachPush(c *wConn) {
+	if !c.reliable {
+		return
+	}
+	chunk := &w.push[xutils.HashCode32(c.uid)%chunkSize]
+	chunk.Lock()
+	if p, ok := chunk.m[c.uid]; ok && p.conn == c {
+		p.conn = nil
+		p.offline = time.Now()
+		p.group = c.group
+	}
+	chunk.Unlock()
+}
+
+// pushUIDs 给指定用户可靠推送
+func (w *websocket) pushUIDs(v interface{}, api string, uis []string) {
+	body := &wsPushBody{v: v}
+	for _, uid := range uis {
+		chunk := &w.push[xutils.HashCode32(uid)%chunkSize]
+		chunk.Lock()
+		if p, ok := chunk.m[uid]; ok {
+			w.addPush(p, api, body)
+		}
+		chunk.Unlock()
+	}
+}
+
+// pushMatch 给匹配分组的用户可靠推送，flag不小于16时推送给全部用户
+// 在线用户的分组属于连接，由SendGroup在会话锁内匹配后传入matched；
+// 断线的用户按断线时保存的分组匹配
+func (w *websocket) pushMatch(v interface{}, api string, flag uint8, group string, matched map[string]struct{}) {
+	body := &wsPushBody{v: v}
+	for i := 0; i < chunkSize; i++ {
+		chunk := &w.push[i]
+		chunk.Lock()
+		for uid, p := range chunk.m {
+			if flag < 16 {
+				if _, ok := matched[uid]; !ok && (p.conn != nil || !p.group.Match(flag, group)) {
+					continue
+				}
+			}
+			w.addPush(p, api, body)
+		}
+		chunk.Unlock()
+	}
+}
+
+// addPush 分配序号并缓存推送，在线时发送
+func (w *websocket) addPush(p *wsPush, api string, body *wsPushBody) {
+	p.seq++
+	m := wsPushMsg{seq: p.seq, api: api, body: body}
+	if n := replaySize(); len(p.msgs) >= n {
+		copy(p.msgs, p.msgs[len(p.msgs)-n+1:])
+		p.msgs = p.msgs[:n-1]
+	}
+	p.msgs = append(p.msgs, m)
+	if p.conn != nil && !p.replaying {
+		w.enqueueFrame(p.conn.conn, p.conn.sender, w.pushFrame(p.conn, m))
+	}
+}
+
+// pushFrame 按连接的编码方式编码推送
+// 数据已编码，每个连接只写入带序号的接口名及帧头(压缩时另行压缩)
+func (w *websocket) pushFrame(c *wConn, m wsPushMsg) *packet.Packet {
+	pack := packet.New(2048)
+	w.encodingResponseData(pack, m.api+"@"+strconv.FormatUint(m.seq, 10), m.body.encoded(c.mode), c.mode, c.deflate)
+	return pack
+}
+
+// resyncFrame 编码推送缺口的通知
+func (w *websocket) resyncFrame(c *wConn, r PushResync) *packet.Packet {
+	pack := packet.New(128)
+	w.encodingResponseData(pack, PushResyncAPI, &r, c.mode, c.deflate)
+	return pack
+}
+
+// enqueueFrame 将已编码的帧加入连接的发送队列
+func (w *websocket) enqueueFrame(conn net.Conn, sender uint32, pack *packet.Packet) {
+	ad := w.NewRespAutoData(pack)
+	w.AddRespConnData(conn, ad, sender)
+	w.freeAutoData(ad)
+}
+
+// sweepPush 清理断线超过保留时间的推送缓存，启用ClusterPush时上报会话下线
+func (w *websocket) sweepPush() {
+	var uids []string
+	expired := time.Now().Add(-resumeGrace())
+	for i := 0; i < chunkSize; i++ {
+		chunk := &w.push[i]
+		chunk.Lock()
+		for uid, p := range chunk.m {
+			if p.conn == nil && p.offline.Before(expired) {
+				delete(chunk.m, uid)
+				uids = append(uids, uid)
+			}
+		}
+		chunk.Unlock()
+	}
+	if !env.config.ClusterPush {
+		return
+	}
+	// 在会话锁内判断是否已重新登入，与RegisterConn的上线上报保持顺序
+	for _, uid := range uids {
+		chunk := &w.session.chunks[xutils.HashCode32(uid)%chunkSize]
+		chunk.RLock()
+		if _, ok := chunk.m[uid]; !ok {
+			env.registry.ReportSession(uid, false)
+		}
+		chunk.RUnlock()
+	}
+}
@@ -0,0 +1,196 @@
+package micro
+
+import (
+	"encoding/binary"
+	"math"
+	"net"
+	"time"
+	"unicode/utf8"
+
+	"github.com/micro/packet"
+)
+
+// wsFragmentSize 大消息拆分为多个帧发送，每帧数据的最大长度
+const wsFragmentSize = 64 << 10
+
+// writeFrameHeader 写入帧头(服务端发送的帧不带掩码)
+func writeFrameHeader(pack *packet.Packet, b byte, size int) {
+	pack.WriteByte(b)
+	switch {
+	case size < 126:
+		pack.WriteByte(byte(size))
+	case size < math.MaxUint16:
+		pack.WriteByte(126)
+		binary.BigEndian.PutUint16(pack.Allocate(2), uint16(size))
+	default:
+		pack.WriteByte(127)
+		binary.BigEndian.PutUint64(pack.Allocate(8), uint64(size))
+	}
+}
+
+// encodeCloseFrame 编码关闭帧，原因最长123字节
+func encodeCloseFrame(pack *packet.Packet, code int, reason string) {
+	for len(reason) > 123 {
+		_, n := utf8.DecodeLastRuneInString(reason)
+		reason = reason[:len(reason)-n]
+	}
+	writeFrameHeader(pack, 0x88, 2+len(reason))
+	binary.BigEndian.PutUint16(pack.Allocate(2), uint16(code))
+	pack.Write([]byte(reason))
+}
+
+// writeClose 直接发送关闭帧
+// 控制帧可以插入在分片消息之间，单次Write写入完整的帧，不会与发送协程的数据交错
+func writeClose(conn net.Conn, code int, reason string) {
+	pack := packet.New(128)
+	encodeCloseFrame(pack, code, reason)
+	pack.SetTimeout(0, time.Second)
+	pack.FlushToConn(conn)
+	packet.Free(pack)
+}
+
+// fragmentMessage 将s之后的消息数据拆分为多个帧
+// opCode为首帧的FIN/RSV1/OPCODE，后续为延续帧，最后一帧设置FIN
+func fragmentMessage(pack *packet.Packet, s int, opCode byte) {
+	payload := packet.New(pack.Size())
+	payload.Write(pack.Slice(s, -1))
+	data := payload.Data()
+
+	pack.Reset()
+	b := opCode &^ 0x80
+	for len(data) > 0 {
+		n := len(data)
+		if n > wsFragmentSize {
+			n = wsFragmentSize
+		} else {
+			b |= 0x80
+		}
+		writeFrameHeader(pack, b, n)
+		pack.Write(data[:n])
+		data = data[n:]
+		b = 0x00
+	}
+	packet.Free(payload)
+}
+
+// frameLen 数据开头的帧的总长度
+func frameLen(data []byte) int {
+	if len(data) < 2 {
+		return len(data)
+	}
+	n, h := int(data[1]&0x7f), 2
+	switch {
+	case n == 126 && len(data) >= 4:
+		n, h = int(binary.BigEndian.Uint16(data[2:])), 4
+	case n == 127 && len(data) >= 10:
+		n, h = int(binary.BigEndian.Uint64(data[2:])), 10
+	case n >= 126:
+		return len(data)
+	}
+	if h+n > len(data) {
+		return len(data)
+	}
+	return h + n
+}
+
+// wsStream 正在分帧发送数据的连接
+type wsStream struct {
+	conn net.Conn
+	// 待发送的数据，第一个为正在发送的消息
+	queue []*wkConnData
+	// 第一个消息已发送的长度
+	offset int
+}
+
+// sendLoop 发送协程
+// 大消息每次只发送一帧，与其他连接的数据轮流发送，避免一个慢连接阻塞整个协程；
+// 同一连接的数据总是由同一个协程按顺序发送
+func (w *websocket) sendLoop(c <-chan *wkConnData) {
+	const WT = time.Second * 10
+
+	var (
+		streams = make(map[net.Conn]*wsStream)
+		active  []*wsStream
+	)
+	defer func() {
+		for _, s := range active {
+			w.freeStream(s)
+		}
+	}()
+
+	for {
+		var (
+			wcd *wkConnData
+			ok  = true
+		)
+		if len(active) == 0 {
+			wcd, ok = <-c
+		} else {
+			select {
+			case wcd, ok = <-c:
+			default:
+			}
+		}
+		if !ok {
+			return
+		}
+
+		if wcd != nil {
+			if s, ok := streams[wcd.conn]; ok {
+				s.queue = append(s.queue, wcd)
+			} else if wcd.ad.pack.Size() <= wsFragmentSize+10 {
+				wcd.ad.pack.SetTimeout(0, WT)
+				wcd.ad.pack.FlushToConn(wcd.conn)
+				w.freeConnData(wcd)
+			} else {
+				s := &wsStream{conn: wcd.conn, queue: []*wkConnData{wcd}}
+				streams[wcd.conn] = s
+				active = append(active, s)
+			}
+		}
+
+		// 轮流发送一帧
+		if len(active) > 0 {
+			s := active[0]
+			active = active[1:]
+			if w.sendFrame(s, WT) {
+				active = append(active, s)
+			} else {
+				delete(streams, s.conn)
+			}
+		}
+	}
+}
+
+// sendFrame 发送一帧，返回连接是否还有待发送的数据
+func (w *websocket) sendFrame(s *wsStream, timeout time.Duration) bool {
+	wcd := s.queue[0]
+	data := wcd.ad.pack.Data()
+	frame := data[s.offset:]
+	frame = frame[:frameLen(frame)]
+
+	s.conn.SetWriteDeadline(time.Now().Add(timeout))
+	if _, err := s.conn.Write(frame); err != nil {
+		w.freeStream(s)
+		return false
+	}
+	s.offset += len(frame)
+	if s.offset < len(data) {
+		return true
+	}
+
+	// 消息发送完成
+	w.freeConnData(wcd)
+	s.queue[0] = nil
+	s.queue = s.queue[1:]
+	s.offset = 0
+	return len(s.queue) > 0
+}
+
+// freeStream 释放连接待发送的数据
+func (w *websocket) freeStream(s *wsStream) {
+	for _, wcd := range s.queue {
+		w.freeConnData(wcd)
+	}
+	s.queue = nil
+}
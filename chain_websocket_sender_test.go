@@ -0,0 +1,94 @@
+package micro
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/micro/packet"
+)
+
+func TestFrameLen(t *testing.T) {
+	frame := func(size int) []byte {
+		pack := packet.New(size + 16)
+		writeFrameHeader(pack, 0x82, size)
+		pack.Write(make([]byte, size))
+		data := append([]byte(nil), pack.Data()...)
+		packet.Free(pack)
+		return data
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"empty", nil, 0},
+		{"one byte", []byte{0x81}, 1},
+		{"empty payload", frame(0), 2},
+		{"short", frame(125), 2 + 125},
+		{"uint16", frame(126), 4 + 126},
+		{"uint16 max", frame(65534), 4 + 65534},
+		{"uint64", frame(65535), 10 + 65535},
+		{"followed", append(frame(10), frame(20)...), 2 + 10},
+		{"truncated", frame(300)[:100], 100},
+		{"truncated header", frame(300)[:3], 3},
+	}
+	for _, tt := range tests {
+		if got := frameLen(tt.data); got != tt.want {
+			t.Errorf("%s: frameLen = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFragmentMessage(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		opCode byte
+		frames []byte // 每帧的首字节
+	}{
+		{"single", 100, 0x82, []byte{0x82}},
+		{"exact", wsFragmentSize, 0x81, []byte{0x81}},
+		{"two", wsFragmentSize + 1, 0x82, []byte{0x02, 0x80}},
+		{"deflated", 2*wsFragmentSize + 10, 0xc1, []byte{0x41, 0x00, 0x80}},
+	}
+	for _, tt := range tests {
+		msg := make([]byte, tt.size)
+		for i := range msg {
+			msg[i] = byte(i)
+		}
+		pack := packet.New(tt.size + 16)
+		pack.Write([]byte("head"))
+		pack.Write(msg)
+		fragmentMessage(pack, 4, tt.opCode)
+
+		var (
+			data    = pack.Data()
+			heads   []byte
+			payload []byte
+		)
+		for len(data) > 0 {
+			n := frameLen(data)
+			heads = append(heads, data[0])
+			h := 2
+			switch data[1] & 0x7f {
+			case 126:
+				h = 4
+			case 127:
+				h = 10
+			}
+			if n-h > wsFragmentSize {
+				t.Errorf("%s: frame payload %d exceeds %d", tt.name, n-h, wsFragmentSize)
+			}
+			payload = append(payload, data[h:n]...)
+			data = data[n:]
+		}
+		if !bytes.Equal(heads, tt.frames) {
+			t.Errorf("%s: frames = %x, want %x", tt.name, heads, tt.frames)
+		}
+		if !bytes.Equal(payload, msg) {
+			t.Errorf("%s: payload mismatch, got %d bytes want %d", tt.name, len(payload), len(msg))
+		}
+		packet.Free(pack)
+	}
+}
@@ -0,0 +1,223 @@
+package micro
+
+import (
+	"sort"
+	"sync"
+)
+
+// chCaller 加入/离开频道的回调
+type chCaller func(channel, uid string)
+
+// channels 频道(公会、队伍、世界、聊天室等)
+// 成员按UID记录在调用Join的实例，与连接无关，断线重连(包括连接到其他实例)后仍在频道中，
+// 直到离开频道
+type channels struct {
+	sync.RWMutex
+
+	// 频道=>成员
+	members map[string]map[string]struct{}
+	// 成员=>加入的频道
+	joined map[string]map[string]struct{}
+
+	onJoin  chCaller
+	onLeave chCaller
+}
+
+// Join 加入频道，返回新加入的成员
+func (c *channels) Join(channel string, uids []string) []string {
+	var added []string
+	c.Lock()
+	if c.members == nil {
+		c.members = make(map[string]map[string]struct{}, 64)
+		c.joined = make(map[string]map[string]struct{}, 1024)
+	}
+	ms, ok := c.members[channel]
+	if !ok {
+		ms = make(map[string]struct{}, len(uids))
+		c.members[channel] = ms
+	}
+	for _, uid := range uids {
+		if _, ok := ms[uid]; ok || uid == "" {
+			continue
+		}
+		ms[uid] = struct{}{}
+		js, ok := c.joined[uid]
+		if !ok {
+			js = make(map[string]struct{}, 4)
+			c.joined[uid] = js
+		}
+		js[channel] = struct{}{}
+		added = append(added, uid)
+	}
+	if len(ms) == 0 {
+		delete(c.members, channel)
+	}
+	onJoin := c.onJoin
+	c.Unlock()
+
+	if onJoin != nil {
+		for _, uid := range added {
+			onJoin(channel, uid)
+		}
+	}
+	return added
+}
+
+// Leave 离开频道，返回离开的成员
+func (c *channels) Leave(channel string, uids []string) []string {
+	var removed []string
+	c.Lock()
+	if ms, ok := c.members[channel]; ok {
+		for _, uid := range uids {
+			if _, ok := ms[uid]; !ok {
+				continue
+			}
+			c.remove(channel, uid, ms)
+			removed = append(removed, uid)
+		}
+	}
+	onLeave := c.onLeave
+	c.Unlock()
+
+	if onLeave != nil {
+		for _, uid := range removed {
+			onLeave(channel, uid)
+		}
+	}
+	return removed
+}
+
+// LeaveAll 离开所有频道，返回离开的频道(按名称排序)
+func (c *channels) LeaveAll(uid string) []string {
+	var left []string
+	c.Lock()
+	for channel := range c.joined[uid] {
+		c.remove(channel, uid, c.members[channel])
+		left = append(left, channel)
+	}
+	onLeave := c.onLeave
+	c.Unlock()
+	sort.Strings(left)
+
+	if onLeave != nil {
+		for _, channel := range left {
+			onLeave(channel, uid)
+		}
+	}
+	return left
+}
+
+// remove 移除成员，频道或成员为空时删除索引
+// 调用前需持有锁
+func (c *channels) remove(channel, uid string, ms map[string]struct{}) {
+	delete(ms, uid)
+	if len(ms) == 0 {
+		delete(c.members, channel)
+	}
+	if js, ok := c.joined[uid]; ok {
+		delete(js, channel)
+		if len(js) == 0 {
+			delete(c.joined, uid)
+		}
+	}
+}
+
+// Members 频道的成员
+func (c *channels) Members(channel string) []string {
+	c.RLock()
+	ms := c.members[channel]
+	uids := make([]string, 0, len(ms))
+	for uid := range ms {
+		uids = append(uids, uid)
+	}
+	c.RUnlock()
+	return uids
+}
+
+// Count 频道的成员数量
+func (c *channels) Count(channel string) int {
+	c.RLock()
+	n := len(c.members[channel])
+	c.RUnlock()
+	return n
+}
+
+// Has 是否为频道的成员
+func (c *channels) Has(channel, uid string) bool {
+	c.RLock()
+	_, ok := c.members[channel][uid]
+	c.RUnlock()
+	return ok
+}
+
+// Joined 成员加入的频道(按名称排序)
+func (c *channels) Joined(uid string) []string {
+	c.RLock()
+	js := c.joined[uid]
+	names := make([]string, 0, len(js))
+	for channel := range js {
+		names = append(names, channel)
+	}
+	c.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// SetChannelHooks 设置加入/离开频道的回调
+// 回调在成员变化后调用，不持有频道的锁，可在回调中推送消息
+func SetChannelHooks(onJoin, onLeave func(channel, uid string)) {
+	env.channels.Lock()
+	env.channels.onJoin, env.channels.onLeave = onJoin, onLeave
+	env.channels.Unlock()
+}
+
+// JoinChannel 将用户加入频道，返回新加入的用户
+func JoinChannel(channel string, uids ...string) []string {
+	return env.channels.Join(channel, uids)
+}
+
+// LeaveChannel 将用户移出频道，返回离开的用户
+func LeaveChannel(channel string, uids ...string) []string {
+	return env.channels.Leave(channel, uids)
+}
+
+// LeaveAllChannels 将用户移出所有频道(如在登出回调中调用)
+func LeaveAllChannels(uid string) []string {
+	return env.channels.LeaveAll(uid)
+}
+
+// ChannelMembers 频道的成员
+func ChannelMembers(channel string) []string {
+	return env.channels.Members(channel)
+}
+
+// ChannelCount 频道的成员数量
+func ChannelCount(channel string) int {
+	return env.channels.Count(channel)
+}
+
+// InChannel 用户是否在频道中
+func InChannel(channel, uid string) bool {
+	return env.channels.Has(channel, uid)
+}
+
+// UserChannels 用户加入的频道
+func UserChannels(uid string) []string {
+	return env.channels.Joined(uid)
+}
+
+// SendDataWithChannel 给频道的成员发送数据
+// 按成员索引发送，不扫描所有会话；启用ClusterPush时同时转发到其他有会话的实例，
+// 由各实例发送给在该实例加入频道的成员
+func SendDataWithChannel(data interface{}, api, channel string) {
+	sendChannelMembers(data, api, channel)
+	env.pusher.ForwardChannel(data, api, channel)
+}
+
+// sendChannelMembers 给在本实例加入频道的成员发送数据
+// 成员的会话在其他实例时按UID转发
+func sendChannelMembers(data interface{}, api, channel string) {
+	if uids := env.channels.Members(channel); len(uids) > 0 {
+		SendDataWithUIDs(data, api, uids)
+	}
+}
@@ -0,0 +1,68 @@
+package micro
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestChannels(t *testing.T) {
+	var (
+		c      channels
+		events []string
+	)
+	c.onJoin = func(channel, uid string) { events = append(events, "join "+channel+":"+uid) }
+	c.onLeave = func(channel, uid string) { events = append(events, "leave "+channel+":"+uid) }
+
+	tests := []struct {
+		name    string
+		op      func() []string
+		ret     []string
+		events  []string
+		members map[string][]string
+	}{
+		{"join", func() []string { return c.Join("guild", []string{"ua", "ub", ""}) },
+			[]string{"ua", "ub"}, []string{"join guild:ua", "join guild:ub"},
+			map[string][]string{"guild": {"ua", "ub"}}},
+		{"join again", func() []string { return c.Join("guild", []string{"ua"}) },
+			nil, nil,
+			map[string][]string{"guild": {"ua", "ub"}}},
+		{"join other", func() []string { return c.Join("team", []string{"ub", "uc"}) },
+			[]string{"ub", "uc"}, []string{"join team:ub", "join team:uc"},
+			map[string][]string{"guild": {"ua", "ub"}, "team": {"ub", "uc"}}},
+		{"leave non member", func() []string { return c.Leave("guild", []string{"uc"}) },
+			nil, nil,
+			map[string][]string{"guild": {"ua", "ub"}, "team": {"ub", "uc"}}},
+		{"leave", func() []string { return c.Leave("team", []string{"uc"}) },
+			[]string{"uc"}, []string{"leave team:uc"},
+			map[string][]string{"guild": {"ua", "ub"}, "team": {"ub"}}},
+		{"leave all", func() []string { return c.LeaveAll("ub") },
+			[]string{"guild", "team"}, []string{"leave guild:ub", "leave team:ub"},
+			map[string][]string{"guild": {"ua"}}},
+		{"leave last", func() []string { return c.Leave("guild", []string{"ua"}) },
+			[]string{"ua"}, []string{"leave guild:ua"},
+			map[string][]string{}},
+	}
+	for _, tt := range tests {
+		events = nil
+		if ret := tt.op(); !reflect.DeepEqual(ret, tt.ret) {
+			t.Errorf("%s: returned %v, want %v", tt.name, ret, tt.ret)
+		}
+		if !reflect.DeepEqual(events, tt.events) {
+			t.Errorf("%s: events %v, want %v", tt.name, events, tt.events)
+		}
+		if len(c.members) != len(tt.members) {
+			t.Errorf("%s: %d channels, want %d", tt.name, len(c.members), len(tt.members))
+		}
+		for channel, want := range tt.members {
+			got := c.Members(channel)
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, want) || c.Count(channel) != len(want) {
+				t.Errorf("%s: %s members %v, want %v", tt.name, channel, got, want)
+			}
+		}
+	}
+	if len(c.joined) != 0 {
+		t.Errorf("joined index not empty: %v", c.joined)
+	}
+}
@@ -0,0 +1,372 @@
+package micro
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sync/atomic"
+	"time"
+
+	"github.com/micro/packet"
+	"github.com/micro/xutils"
+)
+
+// 集群推送的内部RPC接口
+const (
+	// rpcLocateSessions 向注册中心查询会话所在的实例
+	rpcLocateSessions = `micro.sessions.locate`
+	// rpcClusterPush 将推送转发到会话所在的实例
+	rpcClusterPush = `micro.sessions.push`
+)
+
+// clusterPush 转发到其他实例的推送
+// 数据以JSON转发，二进制模式的客户端按JSON格式接收
+type clusterPush struct {
+	from    string
+	uids    []string
+	all     bool
+	flag    uint8
+	group   string
+	channel string
+	api     string
+	data    []byte
+}
+
+// Encode 序列化
+func (p *clusterPush) Encode(pack *packet.Packet) {
+	pack.WriteString(p.from)
+	pack.WriteStrings(p.uids)
+	pack.WriteBool(p.all)
+	pack.WriteByte(p.flag)
+	pack.WriteString(p.group)
+	pack.WriteString(p.channel)
+	pack.WriteString(p.api)
+	pack.WriteBytes(p.data)
+}
+
+// Decode 反序列化
+func (p *clusterPush) Decode(pack *packet.Packet) {
+	p.from = pack.ReadString()
+	p.uids = pack.ReadStrings()
+	p.all = pack.ReadBool()
+	p.flag, _ = pack.ReadByte()
+	p.group = pack.ReadString()
+	p.channel = pack.ReadString()
+	p.api = pack.ReadString()
+	p.data = pack.ReadBytes()
+}
+
+// 会话位置缓存
+const (
+	// clusterLocateTTL 会话所在实例的缓存时间，转发未命中时立即重新查询
+	clusterLocateTTL = time.Second * 10
+	// clusterMissTTL 不在任何实例的会话及有会话的实例列表的缓存时间
+	clusterMissTTL = time.Second
+	// clusterPushQueue 未配置ClusterPushQueue时转发队列的长度
+	clusterPushQueue = 8192
+	// clusterPushBatch 每次合并查询位置的推送数量
+	clusterPushBatch = 256
+)
+
+// clusterLoc 缓存的会话位置，adr为空表示不在任何实例
+type clusterLoc struct {
+	adr    string
+	expire time.Time
+}
+
+// clusterPusher 集群推送
+// 实例将websocket会话的上线/下线上报到注册中心，推送给不在本实例的会话时，
+// 查询会话所在的实例并通过RPC转发；转发在后台按推送的顺序进行。
+// 会话位置在本地缓存，接收方返回未送达的会话，发送方清除缓存并重新查询
+type clusterPusher struct {
+	// 本实例标识，忽略自己转发的推送
+	id string
+	c  chan *clusterPush
+
+	// 会话位置缓存(只在转发协程中访问)
+	locs map[string]clusterLoc
+	// 有会话的实例(全部/分组推送)
+	nodes       []string
+	nodesExpire time.Time
+	swept       time.Time
+
+	// 队列已满丢弃的推送数量及上次记录日志的时间
+	dropped uint64
+	logged  int64
+}
+
+// connChecker 会话是否有活跃连接(断线后保留推送缓存的会话没有连接)
+type connChecker interface {
+	hasConn(uid string) bool
+}
+
+// Init 初始化
+func (c *clusterPusher) Init() {
+	// 注册中心提供会话查询
+	RegisterRPC(rpcLocateSessions, func(dpo Dpo) (interface{}, string) {
+		var uids []string
+		dpo.Parse(&uids)
+		return env.registry.LocateSessions(uids), ""
+	})
+	if !env.config.ClusterPush {
+		return
+	}
+	var b [8]byte
+	rand.Read(b[:])
+	c.id = hex.EncodeToString(b[:])
+	size := env.config.ClusterPushQueue
+	if size <= 0 {
+		size = clusterPushQueue
+	}
+	c.c = make(chan *clusterPush, size)
+	c.locs = make(map[string]clusterLoc, 1024)
+
+	// 返回未送达(没有连接)的会话
+	RegisterRPC(rpcClusterPush, func(dpo Dpo) (interface{}, string) {
+		var p clusterPush
+		dpo.Parse(&p)
+		if p.from == c.id {
+			return nil, ""
+		}
+		return c.deliver(&p), ""
+	})
+	go c.run()
+}
+
+// Forward 将推送转发到其他实例
+// uids为空且all为false时按分组推送
+func (c *clusterPusher) Forward(v interface{}, api string, uids []string, all bool, flag uint8, group string) {
+	if c.c == nil {
+		return
+	}
+	var remote []string
+	for _, uid := range uids {
+		if !env.registry.HasSession(uid) {
+			remote = append(remote, uid)
+		}
+	}
+	if len(uids) > 0 && len(remote) == 0 {
+		return
+	}
+	c.enqueue(v, &clusterPush{from: c.id, uids: remote, all: all, flag: flag, group: group, api: api})
+}
+
+// ForwardChannel 将频道推送转发到其他有会话的实例
+func (c *clusterPusher) ForwardChannel(v interface{}, api, channel string) {
+	if c.c == nil {
+		return
+	}
+	c.enqueue(v, &clusterPush{from: c.id, channel: channel, api: api})
+}
+
+// enqueue 编码数据并加入转发队列
+func (c *clusterPusher) enqueue(v interface{}, p *clusterPush) {
+	var err error
+	if p.data, err = json.Marshal(v); err != nil {
+		Debug("cluster push [%s] encode error: %v", p.api, err)
+		return
+	}
+	select {
+	case c.c <- p:
+	default:
+		// 每秒最多记录一次
+		n := atomic.AddUint64(&c.dropped, 1)
+		now := time.Now().Unix()
+		if last := atomic.LoadInt64(&c.logged); now > last && atomic.CompareAndSwapInt64(&c.logged, last, now) {
+			Logf("cluster push [%s] dropped, queue is full (%d dropped)", p.api, n)
+		}
+	}
+}
+
+// run 按顺序转发推送，合并一批推送的位置查询
+func (c *clusterPusher) run() {
+	batch := make([]*clusterPush, 0, clusterPushBatch)
+	for p := range c.c {
+		batch = append(batch[:0], p)
+	more:
+		for len(batch) < clusterPushBatch {
+			select {
+			case p := <-c.c:
+				batch = append(batch, p)
+			default:
+				break more
+			}
+		}
+
+		// 查询未缓存的位置
+		now := time.Now()
+		var (
+			unknown []string
+			nodes   bool
+		)
+		for _, p := range batch {
+			if len(p.uids) == 0 {
+				nodes = true
+				continue
+			}
+			for _, uid := range p.uids {
+				if loc, ok := c.locs[uid]; !ok || now.After(loc.expire) {
+					unknown = append(unknown, uid)
+				}
+			}
+		}
+		if len(unknown) > 0 {
+			c.cache(unknown, c.locate(unknown), now)
+		}
+		if nodes && now.After(c.nodesExpire) {
+			c.nodes = c.nodes[:0]
+			for adr := range c.locate(nil) {
+				c.nodes = append(c.nodes, adr)
+			}
+			c.nodesExpire = now.Add(clusterMissTTL)
+		}
+
+		for i, p := range batch {
+			if len(p.uids) == 0 {
+				for _, adr := range c.nodes {
+					c.send(p, adr)
+				}
+			} else {
+				c.forward(p)
+			}
+			batch[i] = nil
+		}
+		c.sweep(now)
+	}
+}
+
+// forward 按缓存的位置转发，未送达的会话重新查询位置后再转发一次
+func (c *clusterPusher) forward(p *clusterPush) {
+	sent := make(map[string]string, len(p.uids))
+	for adr, uids := range c.group(p.uids) {
+		fp := *p
+		fp.uids = uids
+		for _, uid := range c.send(&fp, adr) {
+			delete(c.locs, uid)
+			sent[uid] = adr
+		}
+	}
+	if len(sent) == 0 {
+		return
+	}
+
+	missed := make([]string, 0, len(sent))
+	for uid := range sent {
+		missed = append(missed, uid)
+	}
+	c.cache(missed, c.locate(missed), time.Now())
+	retry := make(map[string][]string, 2)
+	for _, uid := range missed {
+		if adr := c.locs[uid].adr; adr != "" && adr != sent[uid] {
+			retry[adr] = append(retry[adr], uid)
+		}
+	}
+	for adr, uids := range retry {
+		fp := *p
+		fp.uids = uids
+		c.send(&fp, adr)
+	}
+}
+
+// group 按缓存的位置分组(地址=>uids)，忽略不在任何实例的会话
+func (c *clusterPusher) group(uids []string) map[string][]string {
+	m := make(map[string][]string, 4)
+	for _, uid := range uids {
+		if adr := c.locs[uid].adr; adr != "" {
+			m[adr] = append(m[adr], uid)
+		}
+	}
+	return m
+}
+
+// send 转发推送到实例，返回未送达的会话
+func (c *clusterPusher) send(p *clusterPush, adr string) []string {
+	var missed []string
+	if err := env.rpc.Call(&missed, p, adr, rpcClusterPush); err != nil {
+		Debug("cluster push [%s] to %s error: %v", p.api, adr, err)
+		return p.uids
+	}
+	return missed
+}
+
+// cache 缓存查询到的位置，未查询到的会话缓存为不在任何实例
+func (c *clusterPusher) cache(uids []string, located map[string][]string, now time.Time) {
+	for _, uid := range uids {
+		c.locs[uid] = clusterLoc{expire: now.Add(clusterMissTTL)}
+	}
+	for adr, us := range located {
+		for _, uid := range us {
+			c.locs[uid] = clusterLoc{adr: adr, expire: now.Add(clusterLocateTTL)}
+		}
+	}
+}
+
+// sweep 定期清理过期的位置缓存
+func (c *clusterPusher) sweep(now time.Time) {
+	if now.Sub(c.swept) < clusterLocateTTL {
+		return
+	}
+	c.swept = now
+	for uid, loc := range c.locs {
+		if now.After(loc.expire) {
+			delete(c.locs, uid)
+		}
+	}
+}
+
+// locate 向所有注册中心查询会话所在的实例(地址=>uids)
+// 实例只注册到一个注册中心，合并各注册中心的结果
+func (c *clusterPusher) locate(uids []string) map[string][]string {
+	located := make(map[string][]string, 4)
+	for _, center := range registryCenters() {
+		var m map[string][]string
+		if err := env.rpc.Call(&m, uids, center, rpcLocateSessions); err != nil {
+			Debug("cluster push locate from %s error: %v", center, err)
+			continue
+		}
+		for adr, us := range m {
+			located[adr] = append(located[adr], us...)
+		}
+	}
+	return located
+}
+
+// deliver 推送给本实例的会话，返回没有连接的会话
+func (c *clusterPusher) deliver(p *clusterPush) []string {
+	data := json.RawMessage(p.data)
+	if p.channel != "" {
+		sendChannelMembers(data, p.api, p.channel)
+		return nil
+	}
+	for _, m := range env.chains {
+		if len(p.uids) > 0 || p.all {
+			m.SendData(data, p.api, p.uids)
+		} else {
+			m.SendGroup(data, p.api, p.flag, p.group)
+		}
+	}
+
+	var missed []string
+	for _, uid := range p.uids {
+		found := false
+		for _, m := range env.chains {
+			if cc, ok := m.(connChecker); ok && cc.hasConn(uid) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missed = append(missed, uid)
+		}
+	}
+	return missed
+}
+
+// Encode 以Prometheus文本格式输出转发队列丢弃的推送数量
+func (c *clusterPusher) Encode(pack *packet.Packet) {
+	pack.Write([]byte("# HELP micro_cluster_push_dropped_total Total number of cluster pushes dropped because the queue was full.\n"))
+	pack.Write([]byte("# TYPE micro_cluster_push_dropped_total counter\n"))
+	pack.Write([]byte("micro_cluster_push_dropped_total "))
+	pack.Write(xutils.ParseIntToBytes(int64(atomic.LoadUint64(&c.dropped))))
+	pack.WriteByte('\n')
+}
@@ -1,6 +1,9 @@
 package micro
 
 import (
+	"context"
+	"net"
+	"net/url"
 	"strings"
 	"sync"
 
@@ -63,6 +66,49 @@ type Dpo interface {
 
 	// GetGroup 获取分组
 	GetGroup(uint8) string
+
+	// JoinChannel 当前用户加入频道
+	JoinChannel(string)
+
+	// LeaveChannel 当前用户离开频道
+	LeaveChannel(string)
+
+	// Context 业务上下文
+	// RPC调用时携带调用方的截止时间及取消信号
+	Context() context.Context
+
+	// SetRoles 设置会话角色
+	SetRoles(...string)
+
+	// HasRole 会话是否拥有角色
+	HasRole(string) bool
+}
+
+// HTTPDpo 第三方http请求的处理对象
+// 通过Route或third-part/前缀调用的业务接口可将Dpo断言为该类型
+type HTTPDpo interface {
+	Dpo
+
+	// Method 请求方法
+	Method() string
+
+	// Path 请求路径(不含开头的'/')
+	Path() string
+
+	// Param 路由中的路径参数
+	Param(string) string
+
+	// Query 查询参数
+	Query(string) string
+
+	// Header 请求头域(名称不区分大小写)
+	Header(string) string
+
+	// Body 原始消息体
+	Body() []byte
+
+	// Values 查询参数及表单消息体中的参数
+	Values() url.Values
 }
 
 // userGroups 分组
@@ -85,6 +131,7 @@ type baseDpo struct {
 	rem   string
 	cache dpoCache
 	group *tUserDpoGroup
+	ctx   context.Context
 }
 
 // LoadUser 加载用户数据
@@ -190,11 +237,7 @@ func (b *baseDpo) DelCache(key string) {
 	delete(b.cache, key)
 }
 func (b *baseDpo) SetRemote(rem string) {
-	if i := strings.LastIndex(rem, ":"); i > 0 {
-		b.rem = rem[:i]
-	} else {
-		b.rem = rem
-	}
+	b.rem = remoteHost(rem)
 }
 func (b *baseDpo) Remote() string {
 	return b.rem
@@ -216,16 +259,84 @@ func (b *baseDpo) GetGroup(flag uint8) string {
 	}
 	return ""
 }
+func (b *baseDpo) JoinChannel(channel string) {
+	if b.uid != "" {
+		env.channels.Join(channel, []string{b.uid})
+	}
+}
+func (b *baseDpo) LeaveChannel(channel string) {
+	if b.uid != "" {
+		env.channels.Leave(channel, []string{b.uid})
+	}
+}
+func (b *baseDpo) Context() context.Context {
+	if b.ctx == nil {
+		return context.Background()
+	}
+	return b.ctx
+}
+func (b *baseDpo) SetRoles(roles ...string) {
+	if b.cache != nil {
+		b.cache[dpoRolesKey] = roles
+	}
+}
+func (b *baseDpo) HasRole(role string) bool {
+	for _, r := range rolesOf(b.cache) {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
 func (b *baseDpo) release() {
 	b.uid = ""
 	b.rem = ""
 	b.cache = nil
 	b.group = nil
+	b.ctx = nil
+}
+
+// clientRemote 客户端地址
+// Remote-Addr头域可以伪造，只有连接来自可信代理时才使用，否则使用连接的地址
+func clientRemote(conn net.Conn, header string) string {
+	adr := conn.RemoteAddr().String()
+	if header == "" || len(env.proxies) == 0 {
+		return adr
+	}
+	host, _, err := net.SplitHostPort(adr)
+	if err != nil {
+		host = adr
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		for _, n := range env.proxies {
+			if n.Contains(ip) {
+				return header
+			}
+		}
+	}
+	return adr
+}
+
+// remoteHost 去除远端地址中的端口
+func remoteHost(rem string) string {
+	if i := strings.LastIndex(rem, ":"); i > 0 {
+		return rem[:i]
+	}
+	return rem
 }
 
 // dpoCache 数据缓存器
 type dpoCache map[string]interface{}
 
+// dpoRolesKey 会话角色在缓存中的键
+const dpoRolesKey = `_roles`
+
+// rolesOf 会话角色
+func rolesOf(cac dpoCache) []string {
+	roles, _ := cac[dpoRolesKey].([]string)
+	return roles
+}
+
 // dpoCachePool dpo数据缓存器池
 var dpoCachePool = sync.Pool{
 	New: func() interface{} {
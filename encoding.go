@@ -0,0 +1,46 @@
+package micro
+
+import (
+	"github.com/micro/packet"
+)
+
+// 二进制模式下响应数据的类型
+// 二进制模式由客户端协商开启(websocket: Sec-WebSocket-Protocol首项为binary; http: Content-Type为application/x-micro-binary)
+const (
+	// binaryData 使用packet.Encoder编码的数据
+	binaryData = 0
+	// binaryJSON 未实现packet.Encoder，使用JSON编码的数据
+	binaryJSON = 1
+	// binaryError 业务错误码
+	binaryError = 2
+)
+
+// binaryEncoded 已按二进制模式编码的响应数据(含类型)
+type binaryEncoded []byte
+
+// encodeBinary 以二进制模式编码响应数据
+func encodeBinary(pack *packet.Packet, v interface{}) {
+	switch d := v.(type) {
+	case binaryEncoded:
+		pack.Write(d)
+	case *errBisResp:
+		pack.WriteByte(binaryError)
+		pack.WriteString(d.ErrCode)
+	case packet.Encoder:
+		pack.WriteByte(binaryData)
+		d.Encode(pack)
+	default:
+		pack.WriteByte(binaryJSON)
+		pack.EncodeJSON(v, false, false)
+	}
+}
+
+// decodeBinary 以二进制模式解码请求参数
+// 未实现packet.Decoder时按JSON解码
+func decodeBinary(pack *packet.Packet, v interface{}) error {
+	if d, ok := v.(packet.Decoder); ok {
+		d.Decode(pack)
+		return nil
+	}
+	return pack.DecodeJSON(v)
+}
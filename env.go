@@ -12,18 +12,48 @@ import (
 var env struct {
 	// 配置信息
 	config struct {
-		Name        string   // 服务名称
-		Address     string   // 监听地址
-		Registry    string   // 注册机地址
-		AssetsCache bool     // web资源是否需要缓存
-		Expired     int      // Session过期时间
-		Mask        string   // 通信掩码
-		OpenAt      string   // 开服时间
-		DBResource  string   // 数据源
-		UserTabName string   // 玩家基础数据存储名称
-		DBSQLs      []string // 需要执行的SQL
-		LogFlags    byte     // lDebug/lLog/lError
-		Extra       []string // 扩展参数
+		Name          string   // 服务名称
+		Address       string   // 监听地址
+		Registry      string   // 注册机地址，多个地址以逗号分隔
+		RegistryPeers []string // 注册中心集群中其他节点的地址
+		Weight        int      // 服务权重(负载均衡)
+		Version       string   // 服务版本号
+		Zone          string   // 服务所在区域
+		AssetsCache   bool     // web资源是否需要缓存
+		Expired       int      // Session过期时间
+		Mask          string   // 通信掩码
+		AuthKeys      []string // HMAC签名密钥(kid:secret)，第一个用于签发，其余仅用于校验
+		AuthLegacy    bool     // 同时接受旧格式(md5)的校验码及Token
+		TokenExpired  int      // Token有效期(秒)
+		PublicAPIs    []string // 允许未登录访问的接口
+		HTTPSession   bool     // http接口只通过Session头域识别用户(默认true)；为false时信任客户端的UID头域，仅用于受信任的内网调用
+		TLSCerts      []string // TLS证书及私钥路径，依次成对出现，多个证书按SNI选择
+		TLSCA         string   // CA证书路径，设置后服务间连接使用双向TLS
+		TLSServerName string   // 服务间连接校验的证书名称，默认为对方的主机地址
+
+		APIRoles     map[string][]string // 接口允许访问的角色(优先于注册时设置的角色)
+		OpenAt       string              // 开服时间
+		DBResource   string              // 数据源
+		UserTabName  string              // 玩家基础数据存储名称
+		DBSQLs       []string            // 需要执行的SQL
+		LogFlags     byte                // lDebug/lLog/lError
+		Extra        []string            // 扩展参数
+		MetricsPath  string              // 监控数据访问路径(为空时不提供，无鉴权，仅应对内网开放)
+		DrainTimeout int                 // 平滑关闭时等待业务完成的最长时间(秒)
+
+		WSDeflate          bool // websocket启用permessage-deflate压缩
+		WSDeflateNoContext bool // 要求客户端压缩时不保留上下文(节省内存)
+		WSReplaySize       int  // 可靠推送每个用户缓存的推送数量(默认128)
+		WSResumeGrace      int  // 可靠推送断线后保留缓存的时间(秒，默认60)
+		ClusterPush        bool // 推送通过注册中心定位会话，转发到会话所在的实例
+		ClusterPushQueue   int  // 集群推送转发队列的长度(默认8192)
+
+		RateRemote float64            // 每个远端地址每秒允许的请求数(0不限制)
+		RateUID    float64            // 每个UID每秒允许的请求数(0不限制)
+		RateAPIs   map[string]float64 // 单个接口对每个UID(或远端地址)每秒允许的请求数
+		RateBurst  int                // 允许的突发请求数(默认与每秒请求数相同)
+
+		TrustedProxies []string // 可信代理的地址(IP或CIDR)，只有来自这些地址的连接才使用Remote-Addr头域
 	}
 
 	// 校验码
@@ -35,9 +65,11 @@ var env struct {
 
 	// 会话
 	userCache packet.Cache
+	sessions  sessions
 
 	// 连接监听
 	lsr net.Listener
+	tls tlsConfig
 
 	// 处理函数
 	chains []chain
@@ -49,12 +81,36 @@ var env struct {
 	rpc rpc
 
 	// 业务接口
-	bis map[string]bisDpo
-	rps map[string]bisDpo
+	bis map[string]*bisAPI
+	rps map[string]*bisAPI
+
+	// http路由
+	routes []*route
+
+	// 全局中间件
+	middlewares []Middleware
+
+	// 监控数据
+	metrics metrics
+
+	// 限流
+	limiter limiter
+	// 可信代理
+	proxies []*net.IPNet
+
+	// 集群推送
+	pusher clusterPusher
+
+	// 频道
+	channels channels
 
 	// 服务器关闭之前执行的函数
 	closeFunc []func()
 
+	// 平滑关闭
+	closing int32
+	calls   int64
+
 	// 文件上传
 	uploadFunc map[string]uploadFunc
 
@@ -84,14 +140,21 @@ func init() {
 	SetLogger(os.Stderr)
 
 	// 业务接口
-	env.bis = make(map[string]bisDpo, 64)
-	env.rps = make(map[string]bisDpo, 64)
+	env.bis = make(map[string]*bisAPI, 64)
+	env.rps = make(map[string]*bisAPI, 64)
 	env.uploadFunc = make(map[string]uploadFunc, 16)
+
+	// 监控数据
+	env.metrics.Init()
+
+	// 限流
+	env.limiter.Init()
 }
 
 // loadConfig 加载配配置信息
 func loadConfig() error {
 	env.config.LogFlags = 255
+	env.config.HTTPSession = true
 	pack := packet.New(1024)
 	err := pack.LoadConfig("./config.json", &env.config)
 	packet.Free(pack)
@@ -108,12 +171,51 @@ func loadConfig() error {
 		env.config.Address = ":" + env.config.Address
 	}
 
+	// 服务权重
+	if env.config.Weight <= 0 {
+		env.config.Weight = 1
+	}
+
+	// 监控数据路径
+	env.config.MetricsPath = strings.Trim(env.config.MetricsPath, "/")
+
+	// 平滑关闭等待时长
+	if env.config.DrainTimeout <= 0 {
+		env.config.DrainTimeout = 10
+	}
+
+	// 可信代理
+	env.proxies = parseProxies(env.config.TrustedProxies)
+
 	// 初始化校验码
-	env.authorize.Init(env.config.Mask)
+	env.authorize.Init(env.config.Mask, env.config.AuthKeys, env.config.AuthLegacy, env.config.TokenExpired)
 
 	return err
 }
 
+// parseProxies 解析可信代理的地址，单个IP视为全长掩码的网段
+func parseProxies(ads []string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, adr := range ads {
+		adr = strings.TrimSpace(adr)
+		if strings.IndexByte(adr, '/') < 0 {
+			if ip := net.ParseIP(adr); ip != nil {
+				bits := 8 * net.IPv6len
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 8*net.IPv4len
+				}
+				nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+		} else if _, n, err := net.ParseCIDR(adr); err == nil {
+			nets = append(nets, n)
+			continue
+		}
+		Debug("invalid trusted proxy %q", adr)
+	}
+	return nets
+}
+
 // localeAddress 获取本机配置的地址
 func localeAddress() string {
 	loadConfig()
@@ -0,0 +1,183 @@
+package micro
+
+import (
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// decodeForm 将表单或查询参数解码到结构体
+// 字段名称依次取form标签、json标签及字段名，"-"忽略该字段；名称匹配不区分大小写
+// 支持字符串、整数、浮点数、布尔值、它们的切片及指针，匿名结构体字段展开处理
+func decodeForm(values url.Values, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errFormTarget
+	}
+	rv = rv.Elem()
+
+	// map[string]string / map[string][]string / url.Values
+	if rv.Kind() == reflect.Map && rv.Type().Key().Kind() == reflect.String {
+		return decodeFormMap(values, rv)
+	}
+	if rv.Kind() != reflect.Struct {
+		return errFormTarget
+	}
+	return decodeFormStruct(values, rv)
+}
+
+// decodeFormMap 解码到map
+func decodeFormMap(values url.Values, rv reflect.Value) error {
+	if rv.IsNil() {
+		rv.Set(reflect.MakeMapWithSize(rv.Type(), len(values)))
+	}
+	et := rv.Type().Elem()
+	for name, vs := range values {
+		ev := reflect.New(et).Elem()
+		if err := setFormValue(ev, vs); err != nil {
+			return err
+		}
+		rv.SetMapIndex(reflect.ValueOf(name).Convert(rv.Type().Key()), ev)
+	}
+	return nil
+}
+
+// decodeFormStruct 解码到结构体
+func decodeFormStruct(values url.Values, rv reflect.Value) error {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		fv := rv.Field(i)
+
+		// 展开匿名结构体
+		if f.Anonymous {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				if fv.Kind() == reflect.Ptr {
+					if fv.IsNil() {
+						if !fv.CanSet() {
+							continue
+						}
+						fv.Set(reflect.New(ft))
+					}
+					fv = fv.Elem()
+				}
+				if err := decodeFormStruct(values, fv); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := formFieldName(f)
+		if name == "" {
+			continue
+		}
+		vs, ok := values[name]
+		if !ok {
+			for k, v := range values {
+				if strings.EqualFold(k, name) {
+					vs, ok = v, true
+					break
+				}
+			}
+		}
+		if !ok || len(vs) == 0 {
+			continue
+		}
+		if err := setFormValue(fv, vs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formFieldName 字段对应的参数名称
+func formFieldName(f reflect.StructField) string {
+	for _, key := range [...]string{`form`, `json`} {
+		tag, ok := f.Tag.Lookup(key)
+		if !ok {
+			continue
+		}
+		if i := strings.IndexByte(tag, ','); i >= 0 {
+			tag = tag[:i]
+		}
+		if tag == "-" {
+			return ""
+		}
+		if tag != "" {
+			return tag
+		}
+	}
+	return f.Name
+}
+
+// setFormValue 设置字段的值
+func setFormValue(fv reflect.Value, vs []string) error {
+	switch fv.Kind() {
+	case reflect.Ptr:
+		if fv.IsNil() {
+			fv.Set(reflect.New(fv.Type().Elem()))
+		}
+		return setFormValue(fv.Elem(), vs)
+	case reflect.Slice:
+		sv := reflect.MakeSlice(fv.Type(), len(vs), len(vs))
+		for i, s := range vs {
+			if err := setFormScalar(sv.Index(i), s); err != nil {
+				return err
+			}
+		}
+		fv.Set(sv)
+		return nil
+	}
+	return setFormScalar(fv, vs[0])
+}
+
+// setFormScalar 设置单个值，空字符串保留零值
+func setFormScalar(fv reflect.Value, s string) error {
+	if s == "" && fv.Kind() != reflect.String {
+		return nil
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(n)
+	case reflect.Ptr:
+		return setFormValue(fv, []string{s})
+	case reflect.Interface:
+		if fv.NumMethod() == 0 {
+			fv.Set(reflect.ValueOf(s))
+		}
+	}
+	return nil
+}
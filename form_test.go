@@ -0,0 +1,94 @@
+package micro
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+type formBase struct {
+	Page int `form:"page"`
+}
+
+type formReq struct {
+	formBase
+	Name    string   `form:"name"`
+	Alias   string   `json:"alias,omitempty"`
+	Count   uint8    `json:"count"`
+	Ratio   float64  // 使用字段名，忽略大小写
+	Enabled bool     `form:"on"`
+	Tags    []string `form:"tag"`
+	IDs     []int64  `form:"id"`
+	Limit   *int     `form:"limit"`
+	Any     interface{}
+	Skip    string `form:"-"`
+	private string
+}
+
+func TestDecodeForm(t *testing.T) {
+	limit := 5
+	tests := []struct {
+		name  string
+		query string
+		want  formReq
+		err   bool
+	}{
+		{"empty", "", formReq{}, false},
+		{"tags", "name=tom&alias=t&count=3&ratio=0.5&on=true&page=2",
+			formReq{formBase: formBase{Page: 2}, Name: "tom", Alias: "t", Count: 3, Ratio: 0.5, Enabled: true}, false},
+		{"case insensitive", "NAME=tom&Ratio=1.5", formReq{Name: "tom", Ratio: 1.5}, false},
+		{"slice", "tag=a&tag=b&id=1&id=2", formReq{Tags: []string{"a", "b"}, IDs: []int64{1, 2}}, false},
+		{"pointer", "limit=5", formReq{Limit: &limit}, false},
+		{"interface", "Any=x", formReq{Any: "x"}, false},
+		{"first value", "name=a&name=b", formReq{Name: "a"}, false},
+		{"empty number", "count=&name=", formReq{}, false},
+		{"skip", "Skip=x&private=y&-=z", formReq{}, false},
+		{"overflow", "count=256", formReq{}, true},
+		{"bad bool", "on=yes", formReq{}, true},
+		{"bad slice", "id=1&id=x", formReq{}, true},
+	}
+	for _, tt := range tests {
+		values, _ := url.ParseQuery(tt.query)
+		var got formReq
+		err := decodeForm(values, &got)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: err = %v, want error %v", tt.name, err, tt.err)
+			continue
+		}
+		if !tt.err && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeFormMap(t *testing.T) {
+	values := url.Values{"a": {"1", "2"}, "b": {"3"}}
+
+	var m map[string]string
+	if err := decodeForm(values, &m); err != nil || !reflect.DeepEqual(m, map[string]string{"a": "1", "b": "3"}) {
+		t.Errorf("map[string]string = %v, %v", m, err)
+	}
+	var ms map[string][]string
+	if err := decodeForm(values, &ms); err != nil || !reflect.DeepEqual(ms, map[string][]string(values)) {
+		t.Errorf("map[string][]string = %v, %v", ms, err)
+	}
+	var mi map[string]int
+	if err := decodeForm(values, &mi); err != nil || !reflect.DeepEqual(mi, map[string]int{"a": 1, "b": 3}) {
+		t.Errorf("map[string]int = %v, %v", mi, err)
+	}
+}
+
+func TestDecodeFormTarget(t *testing.T) {
+	var (
+		s  formReq
+		n  int
+		m  map[int]string
+		np *formReq
+	)
+	tests := []interface{}{s, &n, &m, np, nil}
+	for _, v := range tests {
+		if err := decodeForm(url.Values{}, v); err != errFormTarget {
+			t.Errorf("decodeForm(%T) = %v, want errFormTarget", v, err)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package micro
+
+import (
+	"reflect"
+)
+
+// Handle 注册带类型的业务接口
+// 请求参数按接入方式自动解析(http/websocket使用JSON，rpc优先使用packet.Decoder)
+// 每次调用创建新的req，处理函数可以直接返回req或保留其中的引用
+func Handle[Req, Resp any](api string, f func(dpo Dpo, req *Req) (*Resp, string), opts ...Option) {
+	Register(api, typedHandler(f), append(opts, withTypes[Req, Resp]())...)
+}
+
+// HandleRPC 注册带类型的RPC业务接口
+func HandleRPC[Req, Resp any](api string, f func(dpo Dpo, req *Req) (*Resp, string), opts ...Option) {
+	RegisterRPC(api, typedHandler(f), append(opts, withTypes[Req, Resp]())...)
+}
+
+// typedHandler 将带类型的处理函数转换为业务处理函数
+func typedHandler[Req, Resp any](f func(dpo Dpo, req *Req) (*Resp, string)) bisDpo {
+	return func(dpo Dpo) (interface{}, string) {
+		req := new(Req)
+		dpo.Parse(req)
+		resp, errCode := f(dpo, req)
+		if resp == nil {
+			return nil, errCode
+		}
+		return resp, errCode
+	}
+}
+
+// withTypes 记录业务接口的请求/响应类型
+func withTypes[Req, Resp any]() Option {
+	return func(o *apiOptions) {
+		o.req = reflect.TypeOf((*Req)(nil)).Elem()
+		o.resp = reflect.TypeOf((*Resp)(nil)).Elem()
+	}
+}
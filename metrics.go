@@ -0,0 +1,206 @@
+package micro
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/micro/packet"
+)
+
+// 监控数据的来源
+const (
+	metricsHTTP      = `http`
+	metricsWebsocket = `websocket`
+	metricsRPC       = `rpc`
+
+	// 未注册的接口统一记录在该名称下
+	metricsUnknownAPI = `_unknown`
+)
+
+// metricsBuckets 耗时分布区间(秒)
+var metricsBuckets = [...]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metricsEscaper 标签值转义
+var metricsEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metrics 业务接口监控数据
+type metrics struct {
+	sync.RWMutex
+
+	apis map[string]*apiMetrics
+}
+
+// apiMetrics 单个接口的监控数据
+type apiMetrics struct {
+	chain   string
+	api     string
+	count   uint64
+	sum     int64
+	buckets [len(metricsBuckets)]uint64
+
+	em   sync.Mutex
+	errs map[string]uint64
+}
+
+// Init 初始化
+func (m *metrics) Init() {
+	m.apis = make(map[string]*apiMetrics, 128)
+}
+
+// Observe 记录一次业务调用
+func (m *metrics) Observe(chain, api, errCode string, d time.Duration) {
+	apis := env.bis
+	if chain == metricsRPC {
+		apis = env.rps
+	}
+	if _, ok := apis[api]; !ok {
+		api = metricsUnknownAPI
+	}
+
+	key := chain + ":" + api
+	m.RLock()
+	am, ok := m.apis[key]
+	m.RUnlock()
+	if !ok {
+		m.Lock()
+		if am, ok = m.apis[key]; !ok {
+			am = &apiMetrics{
+				chain: chain,
+				api:   api,
+				errs:  make(map[string]uint64, 4),
+			}
+			m.apis[key] = am
+		}
+		m.Unlock()
+	}
+
+	atomic.AddUint64(&am.count, 1)
+	atomic.AddInt64(&am.sum, int64(d))
+	sec := d.Seconds()
+	for i, le := range metricsBuckets {
+		if sec <= le {
+			atomic.AddUint64(&am.buckets[i], 1)
+			break
+		}
+	}
+	if errCode != "" {
+		am.em.Lock()
+		am.errs[errCode]++
+		am.em.Unlock()
+	}
+}
+
+// Encode 以Prometheus文本格式输出监控数据
+func (m *metrics) Encode(pack *packet.Packet) {
+	m.RLock()
+	keys := make([]string, 0, len(m.apis))
+	for key := range m.apis {
+		keys = append(keys, key)
+	}
+	m.RUnlock()
+	sort.Strings(keys)
+
+	ams := make([]*apiMetrics, 0, len(keys))
+	m.RLock()
+	for _, key := range keys {
+		ams = append(ams, m.apis[key])
+	}
+	m.RUnlock()
+
+	// 调用次数
+	pack.Write([]byte("# HELP micro_api_requests_total Total number of api calls.\n"))
+	pack.Write([]byte("# TYPE micro_api_requests_total counter\n"))
+	for _, am := range ams {
+		m.writeName(pack, `micro_api_requests_total`, am)
+		pack.WriteByte('}')
+		m.writeUint(pack, atomic.LoadUint64(&am.count))
+	}
+
+	// 错误次数
+	pack.Write([]byte("# HELP micro_api_errors_total Total number of api calls by error code.\n"))
+	pack.Write([]byte("# TYPE micro_api_errors_total counter\n"))
+	for _, am := range ams {
+		am.em.Lock()
+		codes := make([]string, 0, len(am.errs))
+		for code := range am.errs {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		for _, code := range codes {
+			m.writeName(pack, `micro_api_errors_total`, am)
+			m.writeLabel(pack, `code`, code)
+			pack.WriteByte('}')
+			m.writeUint(pack, am.errs[code])
+		}
+		am.em.Unlock()
+	}
+
+	// 耗时分布
+	pack.Write([]byte("# HELP micro_api_duration_seconds Api call latency in seconds.\n"))
+	pack.Write([]byte("# TYPE micro_api_duration_seconds histogram\n"))
+	for _, am := range ams {
+		var total uint64
+		for i, le := range metricsBuckets {
+			total += atomic.LoadUint64(&am.buckets[i])
+			m.writeName(pack, `micro_api_duration_seconds_bucket`, am)
+			m.writeLabel(pack, `le`, strconv.FormatFloat(le, 'g', -1, 64))
+			pack.WriteByte('}')
+			m.writeUint(pack, total)
+		}
+		count := atomic.LoadUint64(&am.count)
+		m.writeName(pack, `micro_api_duration_seconds_bucket`, am)
+		m.writeLabel(pack, `le`, `+Inf`)
+		pack.WriteByte('}')
+		m.writeUint(pack, count)
+
+		m.writeName(pack, `micro_api_duration_seconds_sum`, am)
+		pack.WriteByte('}')
+		pack.WriteByte(' ')
+		sum := time.Duration(atomic.LoadInt64(&am.sum)).Seconds()
+		pack.Write(strconv.AppendFloat(nil, sum, 'g', -1, 64))
+		pack.WriteByte('\n')
+
+		m.writeName(pack, `micro_api_duration_seconds_count`, am)
+		pack.WriteByte('}')
+		m.writeUint(pack, count)
+	}
+
+	// 限流
+	env.limiter.Encode(pack)
+
+	// 集群推送
+	if env.config.ClusterPush {
+		env.pusher.Encode(pack)
+	}
+}
+
+// writeName 写入指标名称及公共标签
+func (m *metrics) writeName(pack *packet.Packet, name string, am *apiMetrics) {
+	pack.Write([]byte(name))
+	pack.WriteByte('{')
+	pack.Write([]byte(`chain="`))
+	pack.Write([]byte(am.chain))
+	pack.Write([]byte(`",api="`))
+	pack.Write([]byte(metricsEscaper.Replace(am.api)))
+	pack.WriteByte('"')
+}
+
+// writeLabel 写入标签
+func (m *metrics) writeLabel(pack *packet.Packet, name, value string) {
+	pack.WriteByte(',')
+	pack.Write([]byte(name))
+	pack.Write([]byte(`="`))
+	pack.Write([]byte(metricsEscaper.Replace(value)))
+	pack.WriteByte('"')
+}
+
+// writeUint 写入指标值
+func (m *metrics) writeUint(pack *packet.Packet, v uint64) {
+	pack.WriteByte(' ')
+	pack.Write(strconv.AppendUint(nil, v, 10))
+	pack.WriteByte('\n')
+}
@@ -1,6 +1,7 @@
 package micro
 
 import (
+	"context"
 	"os"
 	"strings"
 )
@@ -8,10 +9,13 @@ import (
 // Service 开启服务
 func Service(onStartup func()) error {
 	var cmd string
+	dir := "./schema"
 	for i := 1; i < len(os.Args); i++ {
-		if os.Args[i-1] == "-s" {
+		switch os.Args[i-1] {
+		case "-s":
 			cmd = strings.TrimSpace(os.Args[i])
-			break
+		case "-o":
+			dir = strings.TrimSpace(os.Args[i])
 		}
 	}
 
@@ -24,36 +28,87 @@ func Service(onStartup func()) error {
 		// 关闭服务
 		return requestCloseService()
 
+	case `schema`:
+		// 导出接口描述及客户端代码
+		return exportSchema(onStartup, dir)
+
 	case `help`:
 		Log("\n" +
 			"-s start: startup service\n" +
-			"-s stop: shutdown running service\n")
+			"-s stop: shutdown running service\n" +
+			"-s schema [-o dir]: export api schema and client stubs\n")
 		return nil
 	}
 }
 
+// Reload 重新加载资源及TLS证书
+func Reload() {
+	for _, m := range env.chains {
+		m.Reload()
+	}
+	if env.tls.enabled {
+		if err := env.tls.Reload(); err != nil {
+			Logf("reload tls certificates error: %v", err)
+		}
+	}
+}
+
 // SendDataAll 给所有远端发送数据
 func SendDataAll(data interface{}, api string) {
 	SendDataWithUIDs(data, api, nil)
 }
 
 // SendDataWithUIDs 给指定的UIDs远端发送数据
+// 启用ClusterPush时，不在本实例的会话转发到所在的实例
 func SendDataWithUIDs(data interface{}, api string, uids []string) {
+	sendDataLocal(data, api, uids)
+	env.pusher.Forward(data, api, uids, len(uids) == 0, 0, "")
+}
+
+// sendDataLocal 只给本实例的会话发送数据(框架内部的通知，如服务关闭)
+func sendDataLocal(data interface{}, api string, uids []string) {
 	for _, m := range env.chains {
 		m.SendData(data, api, uids)
 	}
 }
 
 // SendDataWithGroup 按组分发数据
+// 启用ClusterPush时同时转发到其他有会话的实例
 func SendDataWithGroup(data interface{}, api string, flag uint8, group string) {
 	for _, m := range env.chains {
 		m.SendGroup(data, api, flag, group)
 	}
+	env.pusher.Forward(data, api, nil, false, flag, group)
+}
+
+// Kick 断开用户的websocket连接，客户端收到关闭码及原因
+// code使用4000-4999的应用自定义码(如CloseKickedByRelogin、CloseMaintenance)
+func Kick(uid string, code int, reason string) bool {
+	ok := false
+	for _, m := range env.chains {
+		if m.Kick(uid, code, reason) {
+			ok = true
+		}
+	}
+	return ok
 }
 
 // RPCCenter 远端调用(中心服)
+// 配置了多个注册中心时，连接、握手失败或超时会切换到下一个；远端业务返回的错误直接返回
 func RPCCenter(api string, in, out interface{}) error {
-	adr := env.config.Registry
+	err := errRPCNotFoundService
+	for _, adr := range env.registry.Centers() {
+		err = env.rpc.Call(out, in, adr, api)
+		if _, ok := err.(rpcRemoteError); ok || err == nil {
+			return err
+		}
+	}
+	return err
+}
+
+// RPC 远端调用(指定有服务器)
+func RPC(srvName, api string, in, out interface{}) error {
+	adr := env.registry.ServerAddress(srvName)
 	if adr == "" {
 		return errRPCNotFoundService
 	}
@@ -61,9 +116,10 @@ func RPCCenter(api string, in, out interface{}) error {
 	return env.rpc.Call(out, in, adr, api)
 }
 
-// RPC 远端调用(指定有服务器)
-func RPC(srvName, api string, in, out interface{}) error {
-	adr := env.registry.ServerAddress(srvName)
+// RPCWithKey 远端调用(指定有服务器)，按key选择服务实例
+// 服务使用BalanceHash策略时，相同的key总是路由到同一实例
+func RPCWithKey(srvName, key, api string, in, out interface{}) error {
+	adr := env.registry.ServerAddressWithKey(srvName, key)
 	if adr == "" {
 		return errRPCNotFoundService
 	}
@@ -71,7 +127,20 @@ func RPC(srvName, api string, in, out interface{}) error {
 	return env.rpc.Call(out, in, adr, api)
 }
 
+// RPCContext 远端调用(指定有服务器)
+// ctx的剩余时间及取消信号会传递到远端，远端通过Dpo.Context()获取；ctx没有截止时间时默认30秒超时
+func RPCContext(ctx context.Context, srvName, api string, in, out interface{}) error {
+	adr := env.registry.ServerAddress(srvName)
+	if adr == "" {
+		return errRPCNotFoundService
+	}
+
+	return env.rpc.CallContext(ctx, out, in, adr, api)
+}
+
 const (
+	// StaOPEN 服务器状态：正常
+	StaOPEN = 0
 	// StaBUSY 服务器状态：繁忙状态
 	StaBUSY = 1
 	// StaCLOSE 服务状态：维护中
@@ -79,10 +148,31 @@ const (
 )
 
 // ServerState 服务状态
+// 任一实例正常时返回StaOPEN，全部实例繁忙时返回StaBUSY
 func ServerState(srvName string) byte {
-	adr := env.registry.ServerAddress(srvName)
-	if adr == "" {
-		return StaCLOSE
+	state := byte(StaCLOSE)
+	for _, info := range env.registry.ServerInfos(srvName) {
+		switch info.State {
+		case StaOPEN:
+			return StaOPEN
+		case StaBUSY:
+			state = StaBUSY
+		}
 	}
-	return StaBUSY
+	return state
+}
+
+// ServerInfos 获取服务的所有实例信息
+func ServerInfos(srvName string) []ServerInfo {
+	return env.registry.ServerInfos(srvName)
+}
+
+// SetServerState 设置本服务的状态，并同步到注册中心
+func SetServerState(state byte) {
+	env.registry.SetState(state)
+}
+
+// SetServerLoad 设置本服务的负载及在线人数，并同步到注册中心
+func SetServerLoad(load, online uint32) {
+	env.registry.SetLoad(load, online)
 }
@@ -0,0 +1,132 @@
+package micro
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/micro/packet"
+)
+
+// testCenter 模拟的注册中心
+// ack不为1时拒绝握手，否则对所有请求返回errCode
+type testCenter struct {
+	lsr   net.Listener
+	calls int32
+}
+
+func newTestCenter(t *testing.T, ack int32, errCode string) *testCenter {
+	t.Helper()
+	lsr, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &testCenter{lsr: lsr}
+	go func() {
+		for {
+			conn, err := lsr.Accept()
+			if err != nil {
+				return
+			}
+			go c.serve(conn, ack, errCode)
+		}
+	}()
+	return c
+}
+
+// serve 完成握手并响应请求
+func (c *testCenter) serve(conn net.Conn, ack int32, errCode string) {
+	defer conn.Close()
+	var head []byte
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(head, []byte("\r\n\r\n")) {
+		if _, err := conn.Read(b); err != nil {
+			return
+		}
+		head = append(head, b[0])
+	}
+
+	pack := packet.New(256)
+	defer packet.Free(pack)
+	pack.SetTimeout(time.Second*3, time.Second*3)
+	pack.BeginWrite()
+	pack.WriteI32(ack)
+	pack.EndWrite()
+	if _, err := pack.FlushToConn(conn); err != nil || ack != 1 {
+		return
+	}
+	for pack.ReadConn(conn) == nil {
+		if pack.ReadU32() != rpcCodeRequest {
+			continue
+		}
+		msgID := pack.ReadU64()
+		atomic.AddInt32(&c.calls, 1)
+		pack.BeginWrite()
+		pack.WriteU32(rpcCodeResponse)
+		pack.WriteU64(msgID)
+		pack.WriteU32(rpcCodeDataERR)
+		pack.WriteString(errCode)
+		pack.EndWrite()
+		pack.FlushToConn(conn)
+	}
+}
+
+func TestRPCCenterFailover(t *testing.T) {
+	if env.rpc.cos == nil {
+		env.rpc.Init()
+	}
+	saved := env.config.Registry
+	defer func() { env.config.Registry = saved }()
+
+	// 已关闭的端口
+	lsr, _ := net.Listen("tcp", "127.0.0.1:0")
+	refused := lsr.Addr().String()
+	lsr.Close()
+
+	tests := []struct {
+		name    string
+		centers []int32 // 各中心的握手应答，0为连接被拒绝
+		err     string
+		calls   []int32
+	}{
+		{"first ok", []int32{1, 1}, "Denied", []int32{1, 0}},
+		{"refused", []int32{0, 1}, "Denied", []int32{0, 1}},
+		{"handshake rejected", []int32{2, 1}, "Denied", []int32{0, 1}},
+		{"refused then rejected", []int32{0, 2, 1}, "Denied", []int32{0, 0, 1}},
+		{"all failed", []int32{0, 2}, errRPCTimeout.Error(), []int32{0, 0}},
+	}
+	for _, tt := range tests {
+		var (
+			ads     []string
+			centers []*testCenter
+		)
+		for _, ack := range tt.centers {
+			if ack == 0 {
+				ads = append(ads, refused)
+				centers = append(centers, nil)
+				continue
+			}
+			c := newTestCenter(t, ack, "Denied")
+			ads = append(ads, c.lsr.Addr().String())
+			centers = append(centers, c)
+		}
+		env.config.Registry = strings.Join(ads, ",")
+
+		err := RPCCenter("api", nil, nil)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%s: err = %v, want %s", tt.name, err, tt.err)
+		}
+		for i, c := range centers {
+			if c == nil {
+				continue
+			}
+			if n := atomic.LoadInt32(&c.calls); n != tt.calls[i] {
+				t.Errorf("%s: center %d called %d times, want %d", tt.name, i, n, tt.calls[i])
+			}
+			c.lsr.Close()
+		}
+	}
+}
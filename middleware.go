@@ -0,0 +1,128 @@
+package micro
+
+import (
+	"reflect"
+	"runtime"
+
+	"github.com/micro/packet"
+)
+
+// Handler 业务处理函数
+type Handler func(dpo Dpo) (resp interface{}, errCode string)
+
+// Middleware 业务中间件
+// api 业务接口名称
+// next 下一个处理函数，中间件可决定是否继续调用
+type Middleware func(api string, next Handler) Handler
+
+// Option 业务接口注册选项
+type Option func(*apiOptions)
+
+// apiOptions 业务接口选项
+type apiOptions struct {
+	middlewares []Middleware
+
+	// 是否允许未登录访问
+	public bool
+	// 允许访问的角色，为空不限制
+	roles []string
+
+	// 请求/响应类型(通过Handle注册时记录)
+	req  reflect.Type
+	resp reflect.Type
+}
+
+// WithMiddleware 为单个业务接口设置中间件
+// 接口中间件在全局中间件之后执行
+func WithMiddleware(m ...Middleware) Option {
+	return func(o *apiOptions) {
+		o.middlewares = append(o.middlewares, m...)
+	}
+}
+
+// Public 允许未登录访问业务接口
+func Public() Option {
+	return func(o *apiOptions) {
+		o.public = true
+	}
+}
+
+// WithRoles 设置允许访问业务接口的角色
+// 会话(Dpo.SetRoles)或Token中的角色与之有交集时才能访问
+func WithRoles(roles ...string) Option {
+	return func(o *apiOptions) {
+		o.roles = append(o.roles, roles...)
+	}
+}
+
+// Use 添加全局中间件，作用于http/websocket/rpc的所有业务接口
+// 先添加的中间件先执行；需在服务启动前调用
+func Use(m ...Middleware) {
+	env.middlewares = append(env.middlewares, m...)
+	for _, b := range env.bis {
+		b.build()
+	}
+	for _, b := range env.rps {
+		b.build()
+	}
+	for _, r := range env.routes {
+		r.api.build()
+	}
+}
+
+// bisAPI 已注册的业务接口
+type bisAPI struct {
+	name string
+	kind string
+	df   bisDpo
+	opts apiOptions
+	call bisDpo
+}
+
+// newBisAPI 创建业务接口
+func newBisAPI(kind, api string, df bisDpo, opts []Option) *bisAPI {
+	b := &bisAPI{
+		name: api,
+		kind: kind,
+		df:   df,
+	}
+	for _, opt := range opts {
+		opt(&b.opts)
+	}
+	b.build()
+	return b
+}
+
+// build 组装中间件调用链
+func (b *bisAPI) build() {
+	call := b.df
+	for i := len(b.opts.middlewares) - 1; i >= 0; i-- {
+		call = b.opts.middlewares[i](b.name, call)
+	}
+	for i := len(env.middlewares) - 1; i >= 0; i-- {
+		call = env.middlewares[i](b.name, call)
+	}
+	b.call = b.recover(call)
+}
+
+// recover 捕获业务处理过程中的异常
+func (b *bisAPI) recover(df bisDpo) bisDpo {
+	const errUNKNOWN = `Unknown`
+
+	return func(dpo Dpo) (resp interface{}, errCode string) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			pack := packet.New(1024)
+			buf := pack.Allocate(1024)
+			buf = buf[:runtime.Stack(buf, false)]
+			Debug("\n%s [%s] error: %v\n%s\n\n", b.kind, b.name, err, buf)
+			packet.Free(pack)
+			errCode = errUNKNOWN
+		}()
+		resp, errCode = df(dpo)
+		return
+	}
+}
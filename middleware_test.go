@@ -0,0 +1,106 @@
+package micro
+
+import (
+	"reflect"
+	"testing"
+)
+
+// useTestEnv 使用空的业务接口及全局中间件，返回恢复函数
+func useTestEnv() func() {
+	mws, bis, rps, routes := env.middlewares, env.bis, env.rps, env.routes
+	env.middlewares, env.bis, env.rps, env.routes = nil, make(map[string]*bisAPI), make(map[string]*bisAPI), nil
+	return func() { env.middlewares, env.bis, env.rps, env.routes = mws, bis, rps, routes }
+}
+
+// traceMiddleware 记录调用顺序的中间件
+func traceMiddleware(name string, trace *[]string) Middleware {
+	return func(api string, next Handler) Handler {
+		return func(dpo Dpo) (interface{}, string) {
+			*trace = append(*trace, name+":"+api)
+			return next(dpo)
+		}
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	defer useTestEnv()()
+
+	var trace []string
+	handler := func(dpo Dpo) (interface{}, string) {
+		trace = append(trace, "handler")
+		return "ok", ""
+	}
+	Use(traceMiddleware("g1", &trace), traceMiddleware("g2", &trace))
+	Register("a", handler, WithMiddleware(traceMiddleware("a1", &trace), traceMiddleware("a2", &trace)))
+	Register("b", handler)
+	// 注册后添加的全局中间件作用于已注册的接口
+	Use(traceMiddleware("g3", &trace))
+
+	tests := []struct {
+		api   string
+		trace []string
+	}{
+		{"a", []string{"g1:a", "g2:a", "g3:a", "a1:a", "a2:a", "handler"}},
+		{"b", []string{"g1:b", "g2:b", "g3:b", "handler"}},
+	}
+	for _, tt := range tests {
+		trace = nil
+		call, ok := findBis(tt.api)
+		if !ok {
+			t.Fatalf("%s: not registered", tt.api)
+		}
+		if resp, code := call(nil); resp != "ok" || code != "" {
+			t.Errorf("%s: resp = %v %q", tt.api, resp, code)
+		}
+		if !reflect.DeepEqual(trace, tt.trace) {
+			t.Errorf("%s: trace = %v, want %v", tt.api, trace, tt.trace)
+		}
+	}
+}
+
+func TestMiddlewareShortCircuit(t *testing.T) {
+	defer useTestEnv()()
+
+	called := false
+	Register("a", func(dpo Dpo) (interface{}, string) {
+		called = true
+		return "ok", ""
+	})
+	Use(func(api string, next Handler) Handler {
+		return func(dpo Dpo) (interface{}, string) {
+			return nil, "Denied"
+		}
+	})
+	call, _ := findBis("a")
+	if _, code := call(nil); code != "Denied" || called {
+		t.Errorf("code = %q, handler called %v", code, called)
+	}
+}
+
+func TestMiddlewareRecover(t *testing.T) {
+	defer useTestEnv()()
+
+	var trace []string
+	Use(traceMiddleware("g", &trace))
+	Register("handler panic", func(dpo Dpo) (interface{}, string) {
+		panic("boom")
+	})
+	Register("middleware panic", func(dpo Dpo) (interface{}, string) {
+		return "ok", ""
+	}, WithMiddleware(func(api string, next Handler) Handler {
+		return func(dpo Dpo) (interface{}, string) {
+			panic("boom")
+		}
+	}))
+
+	for _, api := range []string{"handler panic", "middleware panic"} {
+		trace = nil
+		call, _ := findBis(api)
+		if resp, code := call(nil); resp != nil || code != "Unknown" {
+			t.Errorf("%s: resp = %v %q, want Unknown", api, resp, code)
+		}
+		if len(trace) != 1 {
+			t.Errorf("%s: trace = %v", api, trace)
+		}
+	}
+}
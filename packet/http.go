@@ -0,0 +1,273 @@
+package packet
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrHTTPHeader http报头不完整
+	ErrHTTPHeader = errors.New("packet: incomplete http header")
+	// ErrHTTPChunked chunked消息体格式错误
+	ErrHTTPChunked = errors.New("packet: malformed http chunked body")
+	// ErrHTTPBodyTooLarge 消息体超过长度限制
+	ErrHTTPBodyTooLarge = errors.New("packet: http body too large")
+)
+
+// MaxHTTPChunked chunked消息体(含分块格式)读取的最大长度
+var MaxHTTPChunked = 32 << 20
+
+var httpChunkedEncoding = []byte("chunked")
+
+// HTTPField http头域
+type HTTPField struct {
+	Name  string
+	Value string
+}
+
+// HTTPRequest http请求行及头域
+// 服务间连接(rpc/registry等)的报头没有请求行，此时Method、Path及Proto为空
+type HTTPRequest struct {
+	Method string // 请求方法(大写)
+	Path   string // 请求路径(已解码，不含开头的'/'及查询参数)
+	Query  string // 查询参数(未解码)
+	Proto  string // 协议版本，如HTTP/1.1
+	// 路径非法(包含".."等)，此时Path为空
+	Invalid bool
+
+	Fields []HTTPField // 头域
+	query  url.Values
+}
+
+// Reset 重置
+func (r *HTTPRequest) Reset() {
+	r.Method, r.Path, r.Query, r.Proto = "", "", "", ""
+	r.Invalid = false
+	r.Fields = r.Fields[:0]
+	r.query = nil
+}
+
+// Header 头域中的值(名称不区分大小写)
+func (r *HTTPRequest) Header(name string) string {
+	for i := range r.Fields {
+		if strings.EqualFold(r.Fields[i].Name, name) {
+			return r.Fields[i].Value
+		}
+	}
+	return ""
+}
+
+// QueryValues 解码后的查询参数
+func (r *HTTPRequest) QueryValues() url.Values {
+	if r.query == nil {
+		r.query, _ = url.ParseQuery(r.Query)
+		if r.query == nil {
+			r.query = url.Values{}
+		}
+	}
+	return r.query
+}
+
+// KeepAlive 响应后是否保持连接
+// HTTP/1.0默认关闭连接，其他默认保持连接
+func (r *HTTPRequest) KeepAlive() bool {
+	conn := r.Header("Connection")
+	if r.Proto == "HTTP/1.0" {
+		return strings.EqualFold(conn, "keep-alive")
+	}
+	return !strings.EqualFold(conn, "close")
+}
+
+// ParseHTTPRequest 解析缓冲区中的http报头(不移动读位置)
+func (p *Packet) ParseHTTPRequest(req *HTTPRequest) error {
+	req.Reset()
+
+	header := p.buf[p.r:p.w]
+	i := bytes.Index(header, httpHeadEndAt)
+	if i < 0 {
+		return ErrHTTPHeader
+	}
+	header = header[:i+len(httpHeadRowAt)]
+
+	first := true
+	for len(header) > 0 {
+		line := header
+		if i := bytes.Index(header, httpHeadRowAt); i >= 0 {
+			line, header = header[:i], header[i+len(httpHeadRowAt):]
+		} else {
+			header = nil
+		}
+		if first {
+			first = false
+			if parseRequestLine(req, line) {
+				continue
+			}
+		}
+		if i := bytes.IndexByte(line, ':'); i > 0 {
+			req.Fields = append(req.Fields, HTTPField{
+				Name:  string(bytes.TrimSpace(line[:i])),
+				Value: string(bytes.TrimSpace(line[i+1:])),
+			})
+		}
+	}
+	return nil
+}
+
+// parseRequestLine 解析请求行，如GET /path?query HTTP/1.1
+func parseRequestLine(req *HTTPRequest, line []byte) bool {
+	fs := bytes.Fields(line)
+	if len(fs) != 3 || !bytes.HasPrefix(fs[2], []byte("HTTP/")) {
+		return false
+	}
+	req.Method = strings.ToUpper(string(fs[0]))
+	req.Proto = string(fs[2])
+
+	target := string(fs[1])
+	if i := strings.IndexByte(target, '?'); i >= 0 {
+		target, req.Query = target[:i], target[i+1:]
+	}
+	if p, err := url.PathUnescape(target); err == nil {
+		target = p
+	}
+	// 规范化路径，清理后仍包含".."的请求视为非法路径
+	target = path.Clean("/" + target)
+	if strings.Contains(target, "..") {
+		target = "/"
+		req.Invalid = true
+	}
+	req.Path = strings.TrimPrefix(target, "/")
+	return true
+}
+
+// httpHeaderValue 查找头域中的值(名称不区分大小写)
+func httpHeaderValue(header, name []byte) []byte {
+	for len(header) > 0 {
+		line := header
+		if i := bytes.Index(header, httpHeadRowAt); i >= 0 {
+			line, header = header[:i], header[i+len(httpHeadRowAt):]
+		} else {
+			header = nil
+		}
+		if i := bytes.IndexByte(line, ':'); i > 0 && bytes.EqualFold(bytes.TrimSpace(line[:i]), name) {
+			return bytes.TrimSpace(line[i+1:])
+		}
+	}
+	return nil
+}
+
+// httpHeader 缓冲区中的报头部分
+func (p *Packet) httpHeader() []byte {
+	data := p.buf[p.r:p.w]
+	if i := bytes.Index(data, httpHeadEndAt); i >= 0 {
+		return data[:i+len(httpHeadRowAt)]
+	}
+	return data
+}
+
+// isHTTPChunked 消息体是否使用chunked编码
+func (p *Packet) isHTTPChunked() bool {
+	v := httpHeaderValue(p.httpHeader(), []byte("Transfer-Encoding"))
+	return bytes.Contains(bytes.ToLower(v), httpChunkedEncoding)
+}
+
+// readHTTPChunked 读取chunked编码的消息体
+// 调用前缓冲区从0开始存放报头之后已读入的数据，解码后的消息体同样从0开始存放
+func (p *Packet) readHTTPChunked(conn net.Conn) error {
+	// r: 原始数据的读位置 d: 解码数据的写位置
+	r, d := 0, 0
+
+	// fill 追加读取数据，限制读入的总长度
+	fill := func() error {
+		if p.w >= MaxHTTPChunked {
+			return ErrHTTPBodyTooLarge
+		}
+		return p.fillConn(conn)
+	}
+
+	// line 读取一行，返回行尾位置
+	line := func() (int, error) {
+		for {
+			if i := bytes.Index(p.buf[r:p.w], httpHeadRowAt); i >= 0 {
+				return r + i, nil
+			}
+			if err := fill(); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	for {
+		e, err := line()
+		if err != nil {
+			return err
+		}
+		sz := p.buf[r:e]
+		if i := bytes.IndexByte(sz, ';'); i >= 0 {
+			sz = sz[:i]
+		}
+		size, err := strconv.ParseInt(string(bytes.TrimSpace(sz)), 16, 32)
+		if err != nil || size < 0 {
+			return ErrHTTPChunked
+		}
+		r = e + len(httpHeadRowAt)
+
+		// 最后一块，跳过尾部头域
+		if size == 0 {
+			for {
+				e, err := line()
+				if err != nil {
+					return err
+				}
+				empty := e == r
+				r = e + len(httpHeadRowAt)
+				if empty {
+					break
+				}
+			}
+			break
+		}
+
+		n := int(size)
+		if r+n > MaxHTTPChunked {
+			return ErrHTTPBodyTooLarge
+		}
+		for p.w-r < n+len(httpHeadRowAt) {
+			if err := fill(); err != nil {
+				return err
+			}
+		}
+		if !bytes.Equal(p.buf[r+n:r+n+len(httpHeadRowAt)], httpHeadRowAt) {
+			return ErrHTTPChunked
+		}
+		copy(p.buf[d:], p.buf[r:r+n])
+		d += n
+		r += n + len(httpHeadRowAt)
+	}
+
+	p.r = 0
+	p.w = d
+	return nil
+}
+
+// fillConn 从网络连接中追加读取数据
+func (p *Packet) fillConn(conn net.Conn) error {
+	if p.rt > 0 {
+		if err := conn.SetReadDeadline(time.Now().Add(p.rt)); err != nil {
+			return err
+		}
+	}
+	s := p.w
+	buf := p.Allocate(1024)
+	n, err := conn.Read(buf)
+	p.w = s + n
+	if n > 0 {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package packet
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// readerConn 从Reader读取数据的连接
+type readerConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *readerConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func TestParseRequestLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		path    string
+		query   string
+		invalid bool
+	}{
+		{"GET / HTTP/1.1", "", "", false},
+		{"GET /index.html?v=1 HTTP/1.1", "index.html", "v=1", false},
+		{"GET /a/./b//c HTTP/1.1", "a/b/c", "", false},
+		{"GET /a/%20b HTTP/1.1", "a/ b", "", false},
+		{"GET /a/../b HTTP/1.1", "b", "", false},
+		{"GET /../../etc/passwd HTTP/1.1", "etc/passwd", "", false},
+		{"GET /%2e%2e/%2e%2e/etc/passwd HTTP/1.1", "etc/passwd", "", false},
+		{"GET /..%5c..%5cetc HTTP/1.1", "", "", true},
+		{"GET /a..b HTTP/1.1", "", "", true},
+	}
+	for _, tt := range tests {
+		var req HTTPRequest
+		if !parseRequestLine(&req, []byte(tt.line)) {
+			t.Fatalf("parseRequestLine(%q) failed", tt.line)
+		}
+		if req.Path != tt.path || req.Query != tt.query || req.Invalid != tt.invalid {
+			t.Errorf("parseRequestLine(%q) = %q, %q, %v; want %q, %q, %v",
+				tt.line, req.Path, req.Query, req.Invalid, tt.path, tt.query, tt.invalid)
+		}
+	}
+}
+
+func TestReadHTTPChunked(t *testing.T) {
+	tests := []struct {
+		buffered string
+		stream   string
+		body     string
+		err      error
+	}{
+		{"5\r\nhello\r\n0\r\n\r\n", "", "hello", nil},
+		{"5\r\nhel", "lo\r\n6;ext=1\r\n world\r\n0\r\n\r\n", "hello world", nil},
+		{"", "3\r\nabc\r\n0\r\nTrailer: x\r\n\r\n", "abc", nil},
+		{"0\r\n\r\n", "", "", nil},
+		{"x\r\n", "", "", ErrHTTPChunked},
+		{"3\r\nabcd\r\n0\r\n\r\n", "", "", ErrHTTPChunked},
+		{"5\r\nhel", "", "", io.EOF},
+		{"7fffffff\r\n", "", "", ErrHTTPBodyTooLarge},
+	}
+	for _, tt := range tests {
+		p := New(64)
+		p.Write([]byte(tt.buffered))
+		err := p.readHTTPChunked(&readerConn{r: strings.NewReader(tt.stream)})
+		if err != tt.err {
+			t.Errorf("readHTTPChunked(%q+%q) error = %v; want %v", tt.buffered, tt.stream, err, tt.err)
+			continue
+		}
+		if err == nil && string(p.Data()) != tt.body {
+			t.Errorf("readHTTPChunked(%q+%q) = %q; want %q", tt.buffered, tt.stream, p.Data(), tt.body)
+		}
+	}
+}
+
+func TestReadHTTPChunkedLimit(t *testing.T) {
+	saved := MaxHTTPChunked
+	MaxHTTPChunked = 64
+	defer func() { MaxHTTPChunked = saved }()
+
+	// 大量小分块，限制读入的原始数据长度
+	p := New(64)
+	stream := strings.Repeat("1\r\na\r\n", 100) + "0\r\n\r\n"
+	if err := p.readHTTPChunked(&readerConn{r: strings.NewReader(stream)}); err != ErrHTTPBodyTooLarge {
+		t.Fatalf("error = %v; want %v", err, ErrHTTPBodyTooLarge)
+	}
+}
@@ -171,8 +171,10 @@ func (p *Packet) Unescape(s []byte) int {
 }
 
 // ReadHTTPStream 从网络中读取http格式的数据(body)
+// 支持Content-Length及Transfer-Encoding: chunked
 func (p *Packet) ReadHTTPStream(conn net.Conn) (err error) {
 	var bodySize int
+	chunked := p.isHTTPChunked()
 	if i, er := strconv.Atoi(p.HTTPHeaderValue(httpHeadContentLength)); er == nil {
 		bodySize = i
 	} else {
@@ -188,6 +190,9 @@ func (p *Packet) ReadHTTPStream(conn net.Conn) (err error) {
 		p.w = p.w - i
 	}
 
+	if chunked {
+		return p.readHTTPChunked(conn)
+	}
 	if bodySize <= 0 {
 		return
 	}
@@ -215,7 +220,7 @@ func (p *Packet) ReadHTTPBody(conn net.Conn) (err error) {
 	return err
 }
 
-// HTTPHeaderValue http头域中的值
+// HTTPHeaderValue http头域中的值(名称不区分大小写，key可带": "后缀)
 func (p *Packet) HTTPHeaderValue(key []byte) string {
-	return string(bytes.TrimSpace(p.DataBetween(key, httpHeadRowAt)))
+	return string(httpHeaderValue(p.httpHeader(), bytes.TrimRight(key, ": ")))
 }
\ No newline at end of file
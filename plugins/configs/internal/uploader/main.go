@@ -14,7 +14,7 @@ func main() {
 	var (
 		devRemoteAddress string   // 开发地址
 		disRemoteAddress string   // 发布地址
-		serviceMask      string   // 服务器掩码
+		serviceMask      string   // 服务器掩码，HMAC签名时为hmac:kid:secret
 		files            []string // 文件列表
 	)
 
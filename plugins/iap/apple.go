@@ -0,0 +1,164 @@
+package iap
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// AppleVerifier Apple verifyReceipt校验器
+//
+// Deprecated: Apple已弃用verifyReceipt，新接入请使用AppStoreClient及JWSVerifier
+type AppleVerifier struct {
+	sharedSecretKey string
+
+	// 接口地址，为空时使用Apple的正式及沙盒地址
+	ProductionURL string
+	SandboxURL    string
+	// 不接受沙盒收据
+	SkipSandbox bool
+	// http客户端，为空时使用默认客户端
+	Client *http.Client
+}
+
+// Init 初始化实例
+// sharedSecretKey 共享密钥
+func (v *AppleVerifier) Init(sharedSecretKey string) {
+	v.sharedSecretKey = sharedSecretKey
+}
+
+// Verify 验证票据是否合法
+func (v *AppleVerifier) Verify(receiptData string, skipSandbox bool) (productId, transactionId string, err error) {
+	resp, err := v.verifyReceipt(context.Background(), receiptData, skipSandbox)
+	if err != nil {
+		return
+	}
+	if tx, ok := resp.transaction(""); ok {
+		productId, transactionId = tx.ProductID, tx.OriginalTransactionID
+	}
+	return
+}
+
+// VerifyPurchase 验证票据，返回与商品对应的最新交易
+func (v *AppleVerifier) VerifyPurchase(ctx context.Context, p Purchase) (*Receipt, error) {
+	resp, err := v.verifyReceipt(ctx, p.Token, v.SkipSandbox)
+	if err != nil {
+		return nil, err
+	}
+	tx, ok := resp.transaction(p.ProductID)
+	if !ok {
+		return nil, ErrProductMismatch
+	}
+
+	env := EnvProduction
+	if strings.EqualFold(resp.Environment, EnvSandbox) {
+		env = EnvSandbox
+	}
+	return &Receipt{
+		Store:                 StoreApple,
+		ProductID:             tx.ProductID,
+		TransactionID:         tx.TransactionID,
+		OriginalTransactionID: tx.OriginalTransactionID,
+		PurchaseTime:          msTime(tx.PurchaseDateMs),
+		Environment:           env,
+		ExpiresAt:             msTime(tx.ExpiresDateMs),
+	}, nil
+}
+
+// appleTransaction 收据中的交易
+type appleTransaction struct {
+	ProductID             string      `json:"product_id"`
+	TransactionID         string      `json:"transaction_id"`
+	OriginalTransactionID string      `json:"original_transaction_id"`
+	PurchaseDateMs        json.Number `json:"purchase_date_ms"`
+	ExpiresDateMs         json.Number `json:"expires_date_ms"`
+}
+
+// appleResponse verifyReceipt响应
+type appleResponse struct {
+	Status      int    `json:"status"`
+	Environment string `json:"environment"`
+	Receipt     struct {
+		InApp []appleTransaction `json:"in_app"`
+	} `json:"receipt"`
+	LatestReceiptInfo []appleTransaction `json:"latest_receipt_info"`
+}
+
+// transaction 查找商品的最新交易，productID为空时匹配所有商品
+func (r *appleResponse) transaction(productID string) (tx appleTransaction, ok bool) {
+	var latest int64 = -1
+	for _, txs := range [...][]appleTransaction{r.LatestReceiptInfo, r.Receipt.InApp} {
+		for _, t := range txs {
+			if productID != "" && t.ProductID != productID {
+				continue
+			}
+			if ms := msTime(t.PurchaseDateMs).UnixNano(); ms > latest {
+				tx, latest, ok = t, ms, true
+			}
+		}
+		if ok {
+			return
+		}
+	}
+	return
+}
+
+// verifyReceipt 调用verifyReceipt，正式环境返回21007时转到沙盒环境
+func (v *AppleVerifier) verifyReceipt(ctx context.Context, receiptData string, skipSandbox bool) (*appleResponse, error) {
+	u := v.ProductionURL
+	if u == "" {
+		u = backendVerifyUrl
+	}
+	body := map[string]string{
+		`receipt-data`: receiptData,
+		`password`:     v.sharedSecretKey,
+	}
+
+	for i := 0; i < 2; i++ {
+		var resp appleResponse
+		if err := doJSON(ctx, v.Client, http.MethodPost, u, nil, body, &resp); err != nil {
+			return nil, err
+		}
+		switch resp.Status {
+		case respCodeSuccess:
+			return &resp, nil
+		case respCode21007:
+			if skipSandbox {
+				return nil, err21007
+			}
+			u = v.SandboxURL
+			if u == "" {
+				u = sandboxVerifyUrl
+			}
+		default:
+			return nil, appleStatusError(resp.Status)
+		}
+	}
+	return nil, errOthers
+}
+
+// appleStatusError 状态码对应的错误
+func appleStatusError(status int) error {
+	switch status {
+	case respCode21000:
+		return err21000
+	case respCode21002:
+		return err21002
+	case respCode21003:
+		return err21003
+	case respCode21004:
+		return err21004
+	case respCode21005:
+		return err21005
+	case respCode21006:
+		return err21006
+	case respCode21007:
+		return err21007
+	case respCode21008:
+		return err21008
+	case respCode21010:
+		return err21010
+	}
+	return errOthers
+}
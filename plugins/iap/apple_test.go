@@ -0,0 +1,67 @@
+package iap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAppleVerifyPurchase(t *testing.T) {
+	const receipt = `{"status":0,"environment":"%s","receipt":{"in_app":[` +
+		`{"product_id":"gem","transaction_id":"1","original_transaction_id":"1","purchase_date_ms":"1000"},` +
+		`{"product_id":"gem","transaction_id":"2","original_transaction_id":"2","purchase_date_ms":"2000"},` +
+		`{"product_id":"vip","transaction_id":"3","original_transaction_id":"3","purchase_date_ms":"3000"}]}}`
+
+	tests := []struct {
+		name        string
+		prod, sbox  string
+		productID   string
+		skipSandbox bool
+		env, txID   string
+		calls       []string
+		err         error
+	}{
+		{"production", receipt, "", "gem", false, EnvProduction, "2", []string{"prod"}, nil},
+		{"sandbox retry", `{"status":21007}`, receipt, "vip", false, EnvSandbox, "3", []string{"prod", "sandbox"}, nil},
+		{"skip sandbox", `{"status":21007}`, receipt, "vip", true, "", "", []string{"prod"}, err21007},
+		{"product mismatch", receipt, "", "coin", false, "", "", []string{"prod"}, ErrProductMismatch},
+		{"status", `{"status":21003}`, "", "gem", false, "", "", []string{"prod"}, err21003},
+	}
+	for _, tt := range tests {
+		var calls []string
+		handler := func(name, body, env string) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name)
+				var req map[string]string
+				json.NewDecoder(r.Body).Decode(&req)
+				if req[`receipt-data`] != "data" || req[`password`] != "secret" {
+					t.Errorf("%s: request = %v", tt.name, req)
+				}
+				if body == receipt {
+					body = fmt.Sprintf(receipt, env)
+				}
+				w.Write([]byte(body))
+			}
+		}
+		prod := httptest.NewServer(handler("prod", tt.prod, EnvProduction))
+		sbox := httptest.NewServer(handler("sandbox", tt.sbox, EnvSandbox))
+
+		v := &AppleVerifier{ProductionURL: prod.URL, SandboxURL: sbox.URL, SkipSandbox: tt.skipSandbox}
+		v.Init("secret")
+		r, err := v.VerifyPurchase(context.Background(), Purchase{ProductID: tt.productID, Token: "data"})
+		prod.Close()
+		sbox.Close()
+
+		if err != tt.err {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.err)
+		} else if err == nil && (r.Environment != tt.env || r.TransactionID != tt.txID || r.Store != StoreApple) {
+			t.Errorf("%s: receipt = %+v", tt.name, r)
+		}
+		if fmt.Sprint(calls) != fmt.Sprint(tt.calls) {
+			t.Errorf("%s: calls = %v, want %v", tt.name, calls, tt.calls)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package iap
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	appStoreURL        = `https://api.storekit.itunes.apple.com`
+	appStoreSandboxURL = `https://api.storekit-sandbox.itunes.apple.com`
+)
+
+// errInvalidAPIKey App Store Connect API密钥无效
+var errInvalidAPIKey = errors.New(`iap: invalid app store api key`)
+
+// JWSTransaction 签名交易(signedTransactionInfo)的内容
+type JWSTransaction struct {
+	TransactionID         string `json:"transactionId"`
+	OriginalTransactionID string `json:"originalTransactionId"`
+	WebOrderLineItemID    string `json:"webOrderLineItemId"`
+	BundleID              string `json:"bundleId"`
+	ProductID             string `json:"productId"`
+	PurchaseDate          int64  `json:"purchaseDate"`
+	OriginalPurchaseDate  int64  `json:"originalPurchaseDate"`
+	ExpiresDate           int64  `json:"expiresDate"`
+	Quantity              int    `json:"quantity"`
+	Type                  string `json:"type"`
+	AppAccountToken       string `json:"appAccountToken"`
+	InAppOwnershipType    string `json:"inAppOwnershipType"`
+	SignedDate            int64  `json:"signedDate"`
+	RevocationReason      *int   `json:"revocationReason"`
+	RevocationDate        int64  `json:"revocationDate"`
+	Environment           string `json:"environment"`
+}
+
+// Revoked 是否已退款或撤销
+func (t *JWSTransaction) Revoked() bool {
+	return t.RevocationDate > 0
+}
+
+// Receipt 转换为购买凭证
+func (t *JWSTransaction) Receipt() *Receipt {
+	env := EnvProduction
+	if strings.EqualFold(t.Environment, EnvSandbox) {
+		env = EnvSandbox
+	}
+	return &Receipt{
+		Store:                 StoreApple,
+		ProductID:             t.ProductID,
+		TransactionID:         t.TransactionID,
+		OriginalTransactionID: t.OriginalTransactionID,
+		PurchaseTime:          unixMilli(t.PurchaseDate),
+		Environment:           env,
+		ExpiresAt:             unixMilli(t.ExpiresDate),
+	}
+}
+
+// JWSRenewalInfo 签名续订信息(signedRenewalInfo)的内容
+type JWSRenewalInfo struct {
+	OriginalTransactionID  string `json:"originalTransactionId"`
+	AutoRenewProductID     string `json:"autoRenewProductId"`
+	ProductID              string `json:"productId"`
+	AutoRenewStatus        int    `json:"autoRenewStatus"`
+	ExpirationIntent       int    `json:"expirationIntent"`
+	IsInBillingRetryPeriod bool   `json:"isInBillingRetryPeriod"`
+	GracePeriodExpiresDate int64  `json:"gracePeriodExpiresDate"`
+	RenewalDate            int64  `json:"renewalDate"`
+	SignedDate             int64  `json:"signedDate"`
+	Environment            string `json:"environment"`
+}
+
+// DecodeTransaction 校验并解码签名交易
+func (v *JWSVerifier) DecodeTransaction(jws string) (*JWSTransaction, error) {
+	var t JWSTransaction
+	if err := v.Decode(jws, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// DecodeRenewalInfo 校验并解码签名续订信息
+func (v *JWSVerifier) DecodeRenewalInfo(jws string) (*JWSRenewalInfo, error) {
+	var r JWSRenewalInfo
+	if err := v.Decode(jws, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// AppStoreClient App Store Server API客户端
+type AppStoreClient struct {
+	keyID    string
+	issuerID string
+	bundleID string
+	key      *ecdsa.PrivateKey
+	verifier *JWSVerifier
+
+	// 接口地址，为空时按Sandbox选择Apple的地址
+	BaseURL string
+	// 使用沙盒环境
+	Sandbox bool
+	// 生产环境也接受沙盒交易(如App审核使用沙盒支付)，默认拒绝
+	AllowSandbox bool
+	// http客户端，为空时使用默认客户端
+	Client *http.Client
+}
+
+// NewAppStoreClient 创建App Store Server API客户端
+// keyID/issuerID/privateKey App Store Connect中生成的In-App Purchase密钥(.p8)
+// verifier 校验接口返回的签名交易
+func NewAppStoreClient(keyID, issuerID, bundleID string, privateKey []byte, verifier *JWSVerifier) (*AppStoreClient, error) {
+	block, _ := pem.Decode(privateKey)
+	if block == nil {
+		return nil, errInvalidAPIKey
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errInvalidAPIKey
+	}
+	ek, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errInvalidAPIKey
+	}
+	return &AppStoreClient{
+		keyID:    keyID,
+		issuerID: issuerID,
+		bundleID: bundleID,
+		key:      ek,
+		verifier: verifier,
+	}, nil
+}
+
+// Transaction 查询交易
+func (c *AppStoreClient) Transaction(ctx context.Context, transactionID string) (*JWSTransaction, error) {
+	var resp struct {
+		SignedTransactionInfo string `json:"signedTransactionInfo"`
+	}
+	if err := c.get(ctx, `/inApps/v1/transactions/`+url.PathEscape(transactionID), nil, &resp); err != nil {
+		return nil, err
+	}
+	return c.verifier.DecodeTransaction(resp.SignedTransactionInfo)
+}
+
+// History 查询交易历史(transactionID为该用户的任一交易)
+func (c *AppStoreClient) History(ctx context.Context, transactionID string) ([]*JWSTransaction, error) {
+	var (
+		txs      []*JWSTransaction
+		revision string
+	)
+	for {
+		var query url.Values
+		if revision != "" {
+			query = url.Values{`revision`: {revision}}
+		}
+		var resp struct {
+			Revision           string   `json:"revision"`
+			HasMore            bool     `json:"hasMore"`
+			SignedTransactions []string `json:"signedTransactions"`
+		}
+		if err := c.get(ctx, `/inApps/v2/history/`+url.PathEscape(transactionID), query, &resp); err != nil {
+			return nil, err
+		}
+		for _, s := range resp.SignedTransactions {
+			tx, err := c.verifier.DecodeTransaction(s)
+			if err != nil {
+				return nil, err
+			}
+			txs = append(txs, tx)
+		}
+		if !resp.HasMore || resp.Revision == "" {
+			return txs, nil
+		}
+		revision = resp.Revision
+	}
+}
+
+// VerifyPurchase 校验StoreKit 2交易
+// Token为客户端上报的签名交易(jwsRepresentation)时在本地校验，否则作为交易ID查询
+func (c *AppStoreClient) VerifyPurchase(ctx context.Context, p Purchase) (*Receipt, error) {
+	var (
+		tx  *JWSTransaction
+		err error
+	)
+	if strings.Count(p.Token, ".") == 2 {
+		tx, err = c.verifier.DecodeTransaction(p.Token)
+	} else {
+		tx, err = c.Transaction(ctx, p.Token)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.bundleID != "" && tx.BundleID != c.bundleID {
+		return nil, ErrProductMismatch
+	}
+	if p.ProductID != "" && tx.ProductID != p.ProductID {
+		return nil, ErrProductMismatch
+	}
+	// 客户端上报的签名交易可以来自任一环境，校验与客户端的环境一致
+	if sandbox := strings.EqualFold(tx.Environment, EnvSandbox); sandbox != c.Sandbox && !(sandbox && c.AllowSandbox) {
+		return nil, ErrEnvironmentMismatch
+	}
+	if tx.Revoked() {
+		return nil, ErrNotPurchased
+	}
+	return tx.Receipt(), nil
+}
+
+// get 调用接口
+func (c *AppStoreClient) get(ctx context.Context, path string, query url.Values, v interface{}) error {
+	token, err := c.token()
+	if err != nil {
+		return err
+	}
+	base := c.BaseURL
+	if base == "" {
+		base = appStoreURL
+		if c.Sandbox {
+			base = appStoreSandboxURL
+		}
+	}
+	u := strings.TrimRight(base, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	header := http.Header{`Authorization`: {`Bearer ` + token}}
+	return doJSON(ctx, c.Client, http.MethodGet, u, header, nil, v)
+}
+
+// token 接口令牌(ES256签名的JWT，有效期5分钟)
+func (c *AppStoreClient) token() (string, error) {
+	header, err := json.Marshal(map[string]string{`alg`: `ES256`, `kid`: c.keyID, `typ`: `JWT`})
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().Unix()
+	claims, err := json.Marshal(map[string]interface{}{
+		`iss`: c.issuerID,
+		`iat`: now,
+		`exp`: now + 300,
+		`aud`: `appstoreconnect-v1`,
+		`bid`: c.bundleID,
+	})
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	content := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(content))
+	r, s, err := ecdsa.Sign(rand.Reader, c.key, sum[:])
+	if err != nil {
+		return "", err
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return content + "." + enc.EncodeToString(sig), nil
+}
@@ -0,0 +1,226 @@
+package iap
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	googleAPIURL   = `https://androidpublisher.googleapis.com`
+	googleTokenURL = `https://oauth2.googleapis.com/token`
+	googleScope    = `https://www.googleapis.com/auth/androidpublisher`
+)
+
+// Google Play购买状态
+const (
+	googlePurchased = 0
+	googleTest      = 0 // purchaseType: 0为测试购买
+)
+
+// errInvalidServiceAccount 服务账号无效
+var errInvalidServiceAccount = errors.New(`iap: invalid google service account`)
+
+// GoogleVerifier Google Play Developer API校验器
+// 使用服务账号签名的JWT换取访问令牌
+type GoogleVerifier struct {
+	packageName string
+	email       string
+	key         *rsa.PrivateKey
+
+	// 接口地址，为空时使用Google的地址(也可使用服务账号中的token_uri)
+	APIURL   string
+	TokenURL string
+	// http客户端，为空时使用默认客户端
+	Client *http.Client
+
+	mu    sync.Mutex
+	token accessToken
+}
+
+// NewGoogleVerifier 创建Google Play校验器
+// serviceAccount 服务账号的JSON密钥文件内容
+func NewGoogleVerifier(packageName string, serviceAccount []byte) (*GoogleVerifier, error) {
+	var sa struct {
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(serviceAccount, &sa); err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode([]byte(sa.PrivateKey))
+	if block == nil || sa.ClientEmail == "" {
+		return nil, errInvalidServiceAccount
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return nil, errInvalidServiceAccount
+		}
+	}
+	rk, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errInvalidServiceAccount
+	}
+	return &GoogleVerifier{
+		packageName: packageName,
+		email:       sa.ClientEmail,
+		key:         rk,
+		TokenURL:    sa.TokenURI,
+	}, nil
+}
+
+// googleProduct 一次性商品的购买信息
+type googleProduct struct {
+	PurchaseState      int         `json:"purchaseState"`
+	PurchaseType       *int        `json:"purchaseType"`
+	OrderID            string      `json:"orderId"`
+	PurchaseTimeMillis json.Number `json:"purchaseTimeMillis"`
+}
+
+// googleSubscription 订阅的购买信息
+type googleSubscription struct {
+	PaymentState     *int        `json:"paymentState"`
+	PurchaseType     *int        `json:"purchaseType"`
+	OrderID          string      `json:"orderId"`
+	StartTimeMillis  json.Number `json:"startTimeMillis"`
+	ExpiryTimeMillis json.Number `json:"expiryTimeMillis"`
+}
+
+// VerifyPurchase 校验购买令牌
+func (v *GoogleVerifier) VerifyPurchase(ctx context.Context, p Purchase) (*Receipt, error) {
+	token, err := v.accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	base := v.APIURL
+	if base == "" {
+		base = googleAPIURL
+	}
+	kind := `products`
+	if p.Subscription {
+		kind = `subscriptions`
+	}
+	u := strings.Join([]string{
+		strings.TrimRight(base, "/"), `/androidpublisher/v3/applications/`, url.PathEscape(v.packageName),
+		`/purchases/`, kind, `/`, url.PathEscape(p.ProductID), `/tokens/`, url.PathEscape(p.Token),
+	}, "")
+	header := http.Header{`Authorization`: {`Bearer ` + token}}
+
+	r := &Receipt{
+		Store:       StoreGoogle,
+		ProductID:   p.ProductID,
+		Environment: EnvProduction,
+	}
+	if p.Subscription {
+		var sub googleSubscription
+		if err := doJSON(ctx, v.Client, http.MethodGet, u, header, nil, &sub); err != nil {
+			return nil, err
+		}
+		// paymentState: 0待支付 1已支付 2免费试用 3延期升级/降级
+		if sub.PaymentState == nil || *sub.PaymentState == 0 {
+			return nil, ErrNotPurchased
+		}
+		r.TransactionID = sub.OrderID
+		r.OriginalTransactionID = originalOrderID(sub.OrderID)
+		r.PurchaseTime = msTime(sub.StartTimeMillis)
+		r.ExpiresAt = msTime(sub.ExpiryTimeMillis)
+		if sub.PurchaseType != nil && *sub.PurchaseType == googleTest {
+			r.Environment = EnvSandbox
+		}
+		return r, nil
+	}
+
+	var prod googleProduct
+	if err := doJSON(ctx, v.Client, http.MethodGet, u, header, nil, &prod); err != nil {
+		return nil, err
+	}
+	if prod.PurchaseState != googlePurchased {
+		return nil, ErrNotPurchased
+	}
+	r.TransactionID = prod.OrderID
+	r.OriginalTransactionID = prod.OrderID
+	r.PurchaseTime = msTime(prod.PurchaseTimeMillis)
+	if prod.PurchaseType != nil && *prod.PurchaseType == googleTest {
+		r.Environment = EnvSandbox
+	}
+	return r, nil
+}
+
+// originalOrderID 订阅续期订单(GPA.xxx..N)对应的首个订单
+func originalOrderID(orderID string) string {
+	if i := strings.Index(orderID, ".."); i > 0 {
+		return orderID[:i]
+	}
+	return orderID
+}
+
+// accessToken 获取访问令牌，过期前复用
+func (v *GoogleVerifier) accessToken(ctx context.Context) (string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.token.valid() {
+		return v.token.token, nil
+	}
+
+	u := v.TokenURL
+	if u == "" {
+		u = googleTokenURL
+	}
+	assertion, err := v.assertion(u)
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	form := url.Values{
+		`grant_type`: {`urn:ietf:params:oauth:grant-type:jwt-bearer`},
+		`assertion`:  {assertion},
+	}
+	if err := doJSON(ctx, v.Client, http.MethodPost, u, nil, form, &resp); err != nil {
+		return "", err
+	}
+	v.token = accessToken{
+		token:   resp.AccessToken,
+		expired: time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second),
+	}
+	return resp.AccessToken, nil
+}
+
+// assertion 服务账号签名的JWT(RS256)
+func (v *GoogleVerifier) assertion(aud string) (string, error) {
+	now := time.Now().Unix()
+	claims, err := json.Marshal(map[string]interface{}{
+		`iss`:   v.email,
+		`scope`: googleScope,
+		`aud`:   aud,
+		`iat`:   now,
+		`exp`:   now + 3600,
+	})
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	content := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`)) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(content))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, v.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return content + "." + enc.EncodeToString(sig), nil
+}
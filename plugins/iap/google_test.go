@@ -0,0 +1,122 @@
+package iap
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGoogleVerifyPurchase(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+
+	responses := map[string]string{
+		`/products/gem/tokens/paid`:          `{"purchaseState":0,"orderId":"GPA.1","purchaseTimeMillis":"1000"}`,
+		`/products/gem/tokens/test`:          `{"purchaseState":0,"purchaseType":0,"orderId":"GPA.2","purchaseTimeMillis":"1000"}`,
+		`/products/gem/tokens/pending`:       `{"purchaseState":2,"orderId":"GPA.3"}`,
+		`/subscriptions/vip/tokens/renewed`:  `{"paymentState":1,"orderId":"GPA.4..1","startTimeMillis":"1000","expiryTimeMillis":"2000"}`,
+		`/subscriptions/vip/tokens/pending`:  `{"paymentState":0,"orderId":"GPA.5"}`,
+		`/subscriptions/vip/tokens/no-state`: `{"orderId":"GPA.6"}`,
+	}
+	var tokens int
+	mux := http.NewServeMux()
+	mux.HandleFunc(`/token`, func(w http.ResponseWriter, r *http.Request) {
+		tokens++
+		r.ParseForm()
+		if r.Form.Get(`grant_type`) != `urn:ietf:params:oauth:grant-type:jwt-bearer` {
+			t.Errorf("grant_type = %q", r.Form.Get(`grant_type`))
+		}
+		parts := strings.Split(r.Form.Get(`assertion`), ".")
+		if len(parts) != 3 {
+			t.Errorf("assertion = %q", r.Form.Get(`assertion`))
+			http.Error(w, "bad assertion", http.StatusBadRequest)
+			return
+		}
+		sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, sum[:], sig); err != nil {
+			t.Errorf("assertion signature: %v", err)
+		}
+		var claims map[string]interface{}
+		data, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		json.Unmarshal(data, &claims)
+		if claims[`iss`] != `iap@test` || claims[`scope`] != googleScope || claims[`aud`] != `http://`+r.Host+`/token` {
+			t.Errorf("claims = %v", claims)
+		}
+		w.Write([]byte(`{"access_token":"at","expires_in":3600}`))
+	})
+	mux.HandleFunc(`/androidpublisher/v3/applications/com.game/purchases/`, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(`Authorization`) != `Bearer at` {
+			t.Errorf("Authorization = %q", r.Header.Get(`Authorization`))
+		}
+		body, ok := responses[strings.TrimPrefix(r.URL.Path, `/androidpublisher/v3/applications/com.game/purchases`)]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	sa, _ := json.Marshal(map[string]string{
+		`client_email`: `iap@test`,
+		`private_key`:  string(pem.EncodeToMemory(&pem.Block{Type: `PRIVATE KEY`, Bytes: der})),
+		`token_uri`:    srv.URL + `/token`,
+	})
+	v, err := NewGoogleVerifier(`com.game`, sa)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.APIURL = srv.URL
+
+	tests := []struct {
+		name             string
+		p                Purchase
+		env, txID, orgID string
+		expires          bool
+		err              error
+	}{
+		{"product", Purchase{ProductID: "gem", Token: "paid"}, EnvProduction, "GPA.1", "GPA.1", false, nil},
+		{"product test", Purchase{ProductID: "gem", Token: "test"}, EnvSandbox, "GPA.2", "GPA.2", false, nil},
+		{"product pending", Purchase{ProductID: "gem", Token: "pending"}, "", "", "", false, ErrNotPurchased},
+		{"subscription renewed", Purchase{ProductID: "vip", Token: "renewed", Subscription: true}, EnvProduction, "GPA.4..1", "GPA.4", true, nil},
+		{"subscription pending", Purchase{ProductID: "vip", Token: "pending", Subscription: true}, "", "", "", false, ErrNotPurchased},
+		{"subscription no state", Purchase{ProductID: "vip", Token: "no-state", Subscription: true}, "", "", "", false, ErrNotPurchased},
+	}
+	for _, tt := range tests {
+		r, err := v.VerifyPurchase(context.Background(), tt.p)
+		if err != tt.err {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if r.Store != StoreGoogle || r.Environment != tt.env || r.TransactionID != tt.txID ||
+			r.OriginalTransactionID != tt.orgID || r.ExpiresAt.IsZero() == tt.expires {
+			t.Errorf("%s: receipt = %+v", tt.name, r)
+		}
+	}
+
+	_, err = v.VerifyPurchase(context.Background(), Purchase{ProductID: "gem", Token: "unknown"})
+	if e, ok := err.(*StatusError); !ok || e.Status != http.StatusNotFound {
+		t.Errorf("unknown token: err = %v, want status 404", err)
+	}
+	if tokens != 1 {
+		t.Errorf("token requested %d times, want 1", tokens)
+	}
+}
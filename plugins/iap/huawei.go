@@ -0,0 +1,195 @@
+package iap
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	huaweiTokenURL        = `https://oauth-login.cloud.huawei.com/oauth2/v3/token`
+	huaweiOrderURL        = `https://orders-drcn.iap.hicloud.com`
+	huaweiSubscriptionURL = `https://subscr-drcn.iap.hicloud.com`
+)
+
+// 华为购买状态
+const (
+	huaweiPurchased = 0
+	huaweiSandbox   = 0 // purchaseType: 0为沙盒测试
+)
+
+// errHuaweiEmptyData 响应中没有购买数据
+var errHuaweiEmptyData = errors.New(`iap: huawei purchase data is empty`)
+
+// HuaweiError 华为接口返回的错误码
+type HuaweiError struct {
+	Code    string
+	Message string
+}
+
+func (e *HuaweiError) Error() string {
+	return fmt.Sprintf(`iap: huawei response code %s: %s`, e.Code, e.Message)
+}
+
+// HuaweiVerifier 华为IAP服务端校验器
+type HuaweiVerifier struct {
+	clientID     string
+	clientSecret string
+
+	// 接口地址，为空时使用华为中国站点的地址
+	TokenURL        string
+	OrderURL        string
+	SubscriptionURL string
+	// http客户端，为空时使用默认客户端
+	Client *http.Client
+
+	mu    sync.Mutex
+	token accessToken
+}
+
+// NewHuaweiVerifier 创建华为校验器
+// clientID/clientSecret 应用的App ID及密钥
+func NewHuaweiVerifier(clientID, clientSecret string) *HuaweiVerifier {
+	return &HuaweiVerifier{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+	}
+}
+
+// huaweiResponse 接口响应
+type huaweiResponse struct {
+	ResponseCode      string `json:"responseCode"`
+	ResponseMessage   string `json:"responseMessage"`
+	PurchaseTokenData string `json:"purchaseTokenData"`
+	InappPurchaseData string `json:"inappPurchaseData"`
+}
+
+// huaweiPurchase 购买数据
+type huaweiPurchase struct {
+	ProductID      string      `json:"productId"`
+	OrderID        string      `json:"orderId"`
+	PurchaseTime   json.Number `json:"purchaseTime"`
+	PurchaseState  *int        `json:"purchaseState"`
+	PurchaseType   *int        `json:"purchaseType"`
+	ExpirationDate json.Number `json:"expirationDate"`
+	SubIsValid     *bool       `json:"subIsvalid"`
+	OriOrderID     string      `json:"oriOrderId"`
+}
+
+// VerifyPurchase 校验购买令牌
+func (v *HuaweiVerifier) VerifyPurchase(ctx context.Context, p Purchase) (*Receipt, error) {
+	token, err := v.accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{
+		`Authorization`: {`Basic ` + base64.StdEncoding.EncodeToString([]byte(`APPAT:`+token))},
+	}
+
+	var (
+		resp huaweiResponse
+		data string
+	)
+	if p.Subscription {
+		base := v.SubscriptionURL
+		if base == "" {
+			base = huaweiSubscriptionURL
+		}
+		body := map[string]string{`subscriptionId`: p.ProductID, `purchaseToken`: p.Token}
+		if err := doJSON(ctx, v.Client, http.MethodPost, strings.TrimRight(base, "/")+`/sub/applications/v2/purchases/get`, header, body, &resp); err != nil {
+			return nil, err
+		}
+		data = resp.InappPurchaseData
+	} else {
+		base := v.OrderURL
+		if base == "" {
+			base = huaweiOrderURL
+		}
+		body := map[string]string{`productId`: p.ProductID, `purchaseToken`: p.Token}
+		if err := doJSON(ctx, v.Client, http.MethodPost, strings.TrimRight(base, "/")+`/applications/purchases/tokens/verify`, header, body, &resp); err != nil {
+			return nil, err
+		}
+		data = resp.PurchaseTokenData
+	}
+	if resp.ResponseCode != "0" {
+		return nil, &HuaweiError{Code: resp.ResponseCode, Message: resp.ResponseMessage}
+	}
+	if data == "" {
+		return nil, errHuaweiEmptyData
+	}
+
+	var hp huaweiPurchase
+	if err := json.Unmarshal([]byte(data), &hp); err != nil {
+		return nil, err
+	}
+	if p.ProductID != "" && hp.ProductID != "" && hp.ProductID != p.ProductID {
+		return nil, ErrProductMismatch
+	}
+	if p.Subscription {
+		if hp.SubIsValid != nil && !*hp.SubIsValid {
+			return nil, ErrNotPurchased
+		}
+	} else if hp.PurchaseState == nil || *hp.PurchaseState != huaweiPurchased {
+		return nil, ErrNotPurchased
+	}
+
+	r := &Receipt{
+		Store:                 StoreHuawei,
+		ProductID:             hp.ProductID,
+		TransactionID:         hp.OrderID,
+		OriginalTransactionID: hp.OrderID,
+		PurchaseTime:          msTime(hp.PurchaseTime),
+		Environment:           EnvProduction,
+	}
+	if hp.OriOrderID != "" {
+		r.OriginalTransactionID = hp.OriOrderID
+	}
+	if r.ProductID == "" {
+		r.ProductID = p.ProductID
+	}
+	if p.Subscription {
+		r.ExpiresAt = msTime(hp.ExpirationDate)
+	}
+	if hp.PurchaseType != nil && *hp.PurchaseType == huaweiSandbox {
+		r.Environment = EnvSandbox
+	}
+	return r, nil
+}
+
+// accessToken 获取应用级访问令牌，过期前复用
+func (v *HuaweiVerifier) accessToken(ctx context.Context) (string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.token.valid() {
+		return v.token.token, nil
+	}
+
+	u := v.TokenURL
+	if u == "" {
+		u = huaweiTokenURL
+	}
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	form := url.Values{
+		`grant_type`:    {`client_credentials`},
+		`client_id`:     {v.clientID},
+		`client_secret`: {v.clientSecret},
+	}
+	if err := doJSON(ctx, v.Client, http.MethodPost, u, nil, form, &resp); err != nil {
+		return "", err
+	}
+	v.token = accessToken{
+		token:   resp.AccessToken,
+		expired: time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second),
+	}
+	return resp.AccessToken, nil
+}
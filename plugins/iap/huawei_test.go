@@ -0,0 +1,104 @@
+package iap
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHuaweiVerifyPurchase(t *testing.T) {
+	// 购买数据以JSON字符串嵌入响应
+	response := func(field, data string) string {
+		b, _ := json.Marshal(map[string]string{`responseCode`: `0`, field: data})
+		return string(b)
+	}
+	orders := map[string]string{
+		`paid`:     response(`purchaseTokenData`, `{"productId":"gem","orderId":"o1","purchaseTime":1000,"purchaseState":0}`),
+		`sandbox`:  response(`purchaseTokenData`, `{"productId":"gem","orderId":"o2","purchaseTime":1000,"purchaseState":0,"purchaseType":0}`),
+		`canceled`: response(`purchaseTokenData`, `{"productId":"gem","orderId":"o3","purchaseState":1}`),
+		`other`:    response(`purchaseTokenData`, `{"productId":"coin","orderId":"o4","purchaseState":0}`),
+		`empty`:    `{"responseCode":"0"}`,
+		`invalid`:  `{"responseCode":"5","responseMessage":"invalid token"}`,
+	}
+	subs := map[string]string{
+		`valid`:   response(`inappPurchaseData`, `{"productId":"vip","orderId":"s1.2","oriOrderId":"s1","purchaseTime":1000,"expirationDate":2000,"subIsvalid":true}`),
+		`expired`: response(`inappPurchaseData`, `{"productId":"vip","orderId":"s2","subIsvalid":false}`),
+	}
+
+	var tokens int
+	auth := `Basic ` + base64.StdEncoding.EncodeToString([]byte(`APPAT:at`))
+	handler := func(key string, responses map[string]string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get(`Authorization`) != auth {
+				t.Errorf("Authorization = %q", r.Header.Get(`Authorization`))
+			}
+			var req map[string]string
+			json.NewDecoder(r.Body).Decode(&req)
+			body, ok := responses[req[`purchaseToken`]]
+			if !ok || req[key] == "" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(body))
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(`/token`, func(w http.ResponseWriter, r *http.Request) {
+		tokens++
+		r.ParseForm()
+		if r.Form.Get(`grant_type`) != `client_credentials` || r.Form.Get(`client_id`) != `app` || r.Form.Get(`client_secret`) != `secret` {
+			t.Errorf("token form = %v", r.Form)
+		}
+		w.Write([]byte(`{"access_token":"at","expires_in":3600}`))
+	})
+	mux.HandleFunc(`/applications/purchases/tokens/verify`, handler(`productId`, orders))
+	mux.HandleFunc(`/sub/applications/v2/purchases/get`, handler(`subscriptionId`, subs))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	v := NewHuaweiVerifier(`app`, `secret`)
+	v.TokenURL = srv.URL + `/token`
+	v.OrderURL = srv.URL
+	v.SubscriptionURL = srv.URL + `/`
+
+	tests := []struct {
+		name             string
+		p                Purchase
+		env, txID, orgID string
+		expires          bool
+		err              error
+	}{
+		{"product", Purchase{ProductID: "gem", Token: "paid"}, EnvProduction, "o1", "o1", false, nil},
+		{"product sandbox", Purchase{ProductID: "gem", Token: "sandbox"}, EnvSandbox, "o2", "o2", false, nil},
+		{"product canceled", Purchase{ProductID: "gem", Token: "canceled"}, "", "", "", false, ErrNotPurchased},
+		{"product mismatch", Purchase{ProductID: "gem", Token: "other"}, "", "", "", false, ErrProductMismatch},
+		{"empty data", Purchase{ProductID: "gem", Token: "empty"}, "", "", "", false, errHuaweiEmptyData},
+		{"subscription", Purchase{ProductID: "vip", Token: "valid", Subscription: true}, EnvProduction, "s1.2", "s1", true, nil},
+		{"subscription expired", Purchase{ProductID: "vip", Token: "expired", Subscription: true}, "", "", "", false, ErrNotPurchased},
+	}
+	for _, tt := range tests {
+		r, err := v.VerifyPurchase(context.Background(), tt.p)
+		if err != tt.err {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if r.Store != StoreHuawei || r.Environment != tt.env || r.TransactionID != tt.txID ||
+			r.OriginalTransactionID != tt.orgID || r.ExpiresAt.IsZero() == tt.expires {
+			t.Errorf("%s: receipt = %+v", tt.name, r)
+		}
+	}
+
+	_, err := v.VerifyPurchase(context.Background(), Purchase{ProductID: "gem", Token: "invalid"})
+	if e, ok := err.(*HuaweiError); !ok || e.Code != "5" {
+		t.Errorf("invalid token: err = %v, want code 5", err)
+	}
+	if tokens != 1 {
+		t.Errorf("token requested %d times, want 1", tokens)
+	}
+}
@@ -0,0 +1,140 @@
+package iap
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidJWS JWS格式或签名无效
+	ErrInvalidJWS = errors.New(`iap: invalid jws`)
+	// ErrInvalidRootCert 根证书无效
+	ErrInvalidRootCert = errors.New(`iap: invalid root certificate`)
+)
+
+// Apple证书扩展(标识证书用于App Store签名)
+var (
+	oidAppleLeaf         = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 11, 1}
+	oidAppleIntermediate = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 2, 1}
+)
+
+// JWSVerifier App Store签名数据(JWS)的校验器
+// 校验x5c证书链是否由配置的根证书签发，并以叶证书的公钥校验ES256签名
+type JWSVerifier struct {
+	roots *x509.CertPool
+
+	// 证书链校验使用的时间，为空时使用当前时间
+	Now func() time.Time
+	// 不检查Apple证书扩展(使用自签根证书测试时)
+	SkipAppleOID bool
+}
+
+// NewJWSVerifier 创建JWS校验器
+// rootPEM 根证书(Apple Root CA - G3)，PEM或DER格式，可包含多个
+func NewJWSVerifier(rootPEM []byte) (*JWSVerifier, error) {
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(rootPEM) {
+		cert, err := x509.ParseCertificate(rootPEM)
+		if err != nil {
+			return nil, ErrInvalidRootCert
+		}
+		roots.AddCert(cert)
+	}
+	return &JWSVerifier{roots: roots}, nil
+}
+
+// Decode 校验JWS并将载荷以JSON解码到v
+func (v *JWSVerifier) Decode(jws string, out interface{}) error {
+	parts := strings.Split(jws, ".")
+	if len(parts) != 3 {
+		return ErrInvalidJWS
+	}
+	enc := base64.RawURLEncoding
+
+	var header struct {
+		Alg string   `json:"alg"`
+		X5c []string `json:"x5c"`
+	}
+	data, err := enc.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(data, &header) != nil || header.Alg != `ES256` || len(header.X5c) == 0 {
+		return ErrInvalidJWS
+	}
+
+	// 证书链
+	certs := make([]*x509.Certificate, len(header.X5c))
+	for i, s := range header.X5c {
+		der, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return ErrInvalidJWS
+		}
+		if certs[i], err = x509.ParseCertificate(der); err != nil {
+			return err
+		}
+	}
+	if err := v.verifyChain(certs); err != nil {
+		return err
+	}
+
+	// 签名(r||s)
+	pub, ok := certs[0].PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return ErrInvalidJWS
+	}
+	sig, err := enc.DecodeString(parts[2])
+	if err != nil || len(sig) != 64 {
+		return ErrInvalidJWS
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(pub, sum[:], r, s) {
+		return ErrInvalidJWS
+	}
+
+	payload, err := enc.DecodeString(parts[1])
+	if err != nil {
+		return ErrInvalidJWS
+	}
+	return json.Unmarshal(payload, out)
+}
+
+// verifyChain 校验证书链
+func (v *JWSVerifier) verifyChain(certs []*x509.Certificate) error {
+	if !v.SkipAppleOID {
+		if !hasExtension(certs[0], oidAppleLeaf) || len(certs) < 2 || !hasExtension(certs[1], oidAppleIntermediate) {
+			return ErrInvalidJWS
+		}
+	}
+	inters := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		inters.AddCert(c)
+	}
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         v.roots,
+		Intermediates: inters,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
+
+// hasExtension 证书是否包含扩展
+func hasExtension(cert *x509.Certificate, oid asn1.ObjectIdentifier) bool {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,240 @@
+package iap
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCA 自签的测试证书链(根证书->中间证书->叶证书)
+type testCA struct {
+	rootPEM []byte
+	x5c     []string
+	key     *ecdsa.PrivateKey
+}
+
+// newTestCA 创建测试证书链
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	issue := func(name string, ca bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+		tpl := &x509.Certificate{
+			SerialNumber:          serial,
+			Subject:               pkix.Name{CommonName: name},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+			BasicConstraintsValid: true,
+			IsCA:                  ca,
+		}
+		if parent == nil {
+			parent, parentKey = tpl, key
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tpl, parent, &key.PublicKey, parentKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, _ := x509.ParseCertificate(der)
+		return cert, key
+	}
+
+	root, rootKey := issue("test root", true, nil, nil)
+	inter, interKey := issue("test intermediate", true, root, rootKey)
+	leaf, leafKey := issue("test leaf", false, inter, interKey)
+	return &testCA{
+		rootPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw}),
+		x5c:     []string{base64.StdEncoding.EncodeToString(leaf.Raw), base64.StdEncoding.EncodeToString(inter.Raw)},
+		key:     leafKey,
+	}
+}
+
+// sign 以叶证书的私钥签名载荷
+func (ca *testCA) sign(t *testing.T, alg string, payload interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]interface{}{`alg`: alg, `x5c`: ca.x5c})
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := base64.RawURLEncoding
+	content := enc.EncodeToString(header) + "." + enc.EncodeToString(data)
+	sum := sha256.Sum256([]byte(content))
+	r, s, err := ecdsa.Sign(rand.Reader, ca.key, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return content + "." + enc.EncodeToString(sig)
+}
+
+// verifier 信任该证书链根证书的校验器
+func (ca *testCA) verifier(t *testing.T) *JWSVerifier {
+	t.Helper()
+	v, err := NewJWSVerifier(ca.rootPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.SkipAppleOID = true
+	return v
+}
+
+// replacePart 替换JWS的第i段
+func replacePart(jws string, i int, s string) string {
+	parts := strings.Split(jws, ".")
+	parts[i] = s
+	return strings.Join(parts, ".")
+}
+
+func TestJWSDecode(t *testing.T) {
+	ca, other := newTestCA(t), newTestCA(t)
+	v := ca.verifier(t)
+	strict, _ := NewJWSVerifier(ca.rootPEM)
+
+	payload := map[string]string{`productId`: `gem`}
+	valid := ca.sign(t, `ES256`, payload)
+	enc := base64.RawURLEncoding
+	sig, _ := enc.DecodeString(strings.Split(valid, ".")[2])
+	tampered, _ := json.Marshal(map[string]string{`productId`: `vip`})
+
+	tests := []struct {
+		name string
+		v    *JWSVerifier
+		jws  string
+		ok   bool
+	}{
+		{"valid", v, valid, true},
+		{"wrong root", other.verifier(t), valid, false},
+		{"other chain", v, other.sign(t, `ES256`, payload), false},
+		{"apple oid required", strict, valid, false},
+		{"tampered payload", v, replacePart(valid, 1, enc.EncodeToString(tampered)), false},
+		{"alg none", v, ca.sign(t, `none`, payload), false},
+		{"alg RS256", v, ca.sign(t, `RS256`, payload), false},
+		{"short signature", v, replacePart(valid, 2, enc.EncodeToString(sig[:63])), false},
+		{"long signature", v, replacePart(valid, 2, enc.EncodeToString(append(sig, 0))), false},
+		{"no x5c", v, replacePart(valid, 0, enc.EncodeToString([]byte(`{"alg":"ES256"}`))), false},
+		{"malformed", v, "a.b", false},
+	}
+	for _, tt := range tests {
+		var out map[string]string
+		err := tt.v.Decode(tt.jws, &out)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v, want ok %v", tt.name, err, tt.ok)
+		} else if tt.ok && out[`productId`] != `gem` {
+			t.Errorf("%s: payload = %v", tt.name, out)
+		}
+	}
+
+	// 证书过期
+	v.Now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if err := v.Decode(valid, new(map[string]string)); err == nil {
+		t.Error("expired chain: decoded without error")
+	}
+}
+
+func TestAppStoreVerifyPurchase(t *testing.T) {
+	ca := newTestCA(t)
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	p8 := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	tx := func(env, bundle string, revoked bool) string {
+		jt := JWSTransaction{TransactionID: "1", OriginalTransactionID: "1", BundleID: bundle, ProductID: "gem", PurchaseDate: 1000, Environment: env}
+		if revoked {
+			jt.RevocationDate = 2000
+		}
+		return ca.sign(t, `ES256`, jt)
+	}
+
+	tests := []struct {
+		name         string
+		sandbox      bool
+		allowSandbox bool
+		token        string
+		productID    string
+		env          string
+		err          error
+	}{
+		{"production", false, false, tx(EnvProduction, "com.game", false), "gem", EnvProduction, nil},
+		{"sandbox tx rejected", false, false, tx(EnvSandbox, "com.game", false), "gem", "", ErrEnvironmentMismatch},
+		{"sandbox tx allowed", false, true, tx(EnvSandbox, "com.game", false), "gem", EnvSandbox, nil},
+		{"sandbox client", true, false, tx(EnvSandbox, "com.game", false), "gem", EnvSandbox, nil},
+		{"production tx on sandbox", true, false, tx(EnvProduction, "com.game", false), "gem", "", ErrEnvironmentMismatch},
+		{"production tx on sandbox allowed", true, true, tx(EnvProduction, "com.game", false), "gem", "", ErrEnvironmentMismatch},
+		{"bundle mismatch", false, false, tx(EnvProduction, "com.other", false), "gem", "", ErrProductMismatch},
+		{"product mismatch", false, false, tx(EnvProduction, "com.game", false), "vip", "", ErrProductMismatch},
+		{"revoked", false, false, tx(EnvProduction, "com.game", true), "gem", "", ErrNotPurchased},
+	}
+	for _, tt := range tests {
+		c, err := NewAppStoreClient("kid", "issuer", "com.game", p8, ca.verifier(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Sandbox, c.AllowSandbox = tt.sandbox, tt.allowSandbox
+		r, err := c.VerifyPurchase(context.Background(), Purchase{ProductID: tt.productID, Token: tt.token})
+		if err != tt.err {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.err)
+		} else if err == nil && (r.Environment != tt.env || r.TransactionID != "1" || r.Store != StoreApple) {
+			t.Errorf("%s: receipt = %+v", tt.name, r)
+		}
+	}
+}
+
+func TestDecodeNotification(t *testing.T) {
+	ca, other := newTestCA(t), newTestCA(t)
+	v := ca.verifier(t)
+
+	notify := func(signer *testCA) []byte {
+		var n Notification
+		n.NotificationType = NotifyRefund
+		n.NotificationUUID = "uuid"
+		n.Data.BundleID = "com.game"
+		n.Data.Environment = EnvProduction
+		n.Data.SignedTransactionInfo = signer.sign(t, `ES256`, JWSTransaction{TransactionID: "1", ProductID: "gem", RevocationDate: 2000})
+		n.Data.SignedRenewalInfo = signer.sign(t, `ES256`, JWSRenewalInfo{OriginalTransactionID: "1", AutoRenewStatus: 1})
+		// Transaction/RenewalInfo不参与编码，只保留签名数据
+		body, _ := json.Marshal(map[string]string{`signedPayload`: ca.sign(t, `ES256`, n)})
+		return body
+	}
+
+	n, err := v.DecodeNotification(notify(ca))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.NotificationType != NotifyRefund || n.Data.BundleID != "com.game" {
+		t.Errorf("notification = %+v", n)
+	}
+	if n.Transaction == nil || n.Transaction.TransactionID != "1" || !n.Transaction.Revoked() {
+		t.Errorf("transaction = %+v", n.Transaction)
+	}
+	if n.RenewalInfo == nil || n.RenewalInfo.AutoRenewStatus != 1 {
+		t.Errorf("renewal info = %+v", n.RenewalInfo)
+	}
+
+	// 外层签名有效，内层交易由其他证书链签名
+	if _, err := v.DecodeNotification(notify(other)); err == nil {
+		t.Error("nested transaction from another chain: decoded without error")
+	}
+	if _, err := v.DecodeNotification([]byte(`{"signedPayload":"a.b.c"}`)); err == nil {
+		t.Error("invalid payload: decoded without error")
+	}
+	if _, err := v.DecodeNotification([]byte(`not json`)); err == nil {
+		t.Error("invalid body: decoded without error")
+	}
+}
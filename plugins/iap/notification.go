@@ -0,0 +1,95 @@
+package iap
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/micro"
+)
+
+// App Store Server Notifications V2 通知类型(常用)
+const (
+	NotifySubscribed             = `SUBSCRIBED`
+	NotifyDidRenew               = `DID_RENEW`
+	NotifyDidFailToRenew         = `DID_FAIL_TO_RENEW`
+	NotifyDidChangeRenewalStatus = `DID_CHANGE_RENEWAL_STATUS`
+	NotifyExpired                = `EXPIRED`
+	NotifyRefund                 = `REFUND`
+	NotifyRefundReversed         = `REFUND_REVERSED`
+	NotifyRevoke                 = `REVOKE`
+	NotifyConsumptionRequest     = `CONSUMPTION_REQUEST`
+	NotifyTest                   = `TEST`
+)
+
+// Notification App Store Server Notifications V2 通知
+type Notification struct {
+	NotificationType string `json:"notificationType"`
+	Subtype          string `json:"subtype"`
+	NotificationUUID string `json:"notificationUUID"`
+	Version          string `json:"version"`
+	SignedDate       int64  `json:"signedDate"`
+	Data             struct {
+		AppAppleID            int64  `json:"appAppleId"`
+		BundleID              string `json:"bundleId"`
+		BundleVersion         string `json:"bundleVersion"`
+		Environment           string `json:"environment"`
+		Status                int    `json:"status"`
+		SignedTransactionInfo string `json:"signedTransactionInfo"`
+		SignedRenewalInfo     string `json:"signedRenewalInfo"`
+	} `json:"data"`
+
+	// 已校验并解码的交易及续订信息(通知中没有时为nil)
+	Transaction *JWSTransaction `json:"-"`
+	RenewalInfo *JWSRenewalInfo `json:"-"`
+}
+
+// DecodeNotification 校验并解码通知的消息体({"signedPayload":"..."})
+func (v *JWSVerifier) DecodeNotification(body []byte) (*Notification, error) {
+	var req struct {
+		SignedPayload string `json:"signedPayload"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	var n Notification
+	if err := v.Decode(req.SignedPayload, &n); err != nil {
+		return nil, err
+	}
+	if s := n.Data.SignedTransactionInfo; s != "" {
+		tx, err := v.DecodeTransaction(s)
+		if err != nil {
+			return nil, err
+		}
+		n.Transaction = tx
+	}
+	if s := n.Data.SignedRenewalInfo; s != "" {
+		ri, err := v.DecodeRenewalInfo(s)
+		if err != nil {
+			return nil, err
+		}
+		n.RenewalInfo = ri
+	}
+	return &n, nil
+}
+
+// RegisterNotifications 注册接收App Store Server Notifications V2的接口
+// 通知地址为 http(s)://host/third-part/{api}
+// f返回错误时响应500，Apple会稍后重试；校验失败的通知直接响应400
+func RegisterNotifications(api string, v *JWSVerifier, f func(*Notification) error) {
+	micro.Register(api, func(dpo micro.Dpo) (interface{}, string) {
+		hd, ok := dpo.(micro.HTTPDpo)
+		if !ok {
+			return micro.HTTPStatus(http.StatusBadRequest), ""
+		}
+		n, err := v.DecodeNotification(hd.Body())
+		if err != nil {
+			micro.Debug("iap notification decode error: %v", err)
+			return micro.HTTPStatus(http.StatusBadRequest), ""
+		}
+		if err := f(n); err != nil {
+			micro.Debug("iap notification [%s] %s error: %v", n.NotificationType, n.NotificationUUID, err)
+			return micro.HTTPStatus(http.StatusInternalServerError), ""
+		}
+		return micro.HTTPStatus(http.StatusOK), ""
+	}, micro.Public())
+}
@@ -2,112 +2,157 @@ package iap
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
+)
+
+// 商店
+const (
+	StoreApple  = `apple`
+	StoreGoogle = `google`
+	StoreHuawei = `huawei`
+)
+
+// 环境
+const (
+	EnvProduction = `Production`
+	EnvSandbox    = `Sandbox`
+)
 
-	"github.com/micro/xutils"
+var (
+	// ErrNotPurchased 订单未支付(已取消或待支付)
+	ErrNotPurchased = errors.New(`iap: purchase is not completed`)
+	// ErrProductMismatch 凭证中没有指定的商品
+	ErrProductMismatch = errors.New(`iap: product mismatch`)
+	// ErrEnvironmentMismatch 交易的环境(沙盒/生产)与客户端不一致
+	ErrEnvironmentMismatch = errors.New(`iap: environment mismatch`)
 )
 
-type Verifier struct {
-	sharedSecretKey string
+// Purchase 客户端上报的购买信息
+type Purchase struct {
+	// 商品ID(Google/Huawei必填；Apple用于在收据中查找对应的交易)
+	ProductID string
+	// 购买凭证(Apple: receipt-data; Google/Huawei: purchaseToken)
+	Token string
+	// 是否为订阅商品
+	Subscription bool
+}
+
+// Receipt 校验通过的购买凭证
+type Receipt struct {
+	Store                 string
+	ProductID             string
+	TransactionID         string
+	OriginalTransactionID string
+	PurchaseTime          time.Time
+	Environment           string
+	// 订阅到期时间，非订阅商品为零值
+	ExpiresAt time.Time
 }
 
-// Init 初始化实例
-// sharedSecretKey 共享密钥
-func (v *Verifier) Init(sharedSecretKey string) {
-	v.sharedSecretKey = sharedSecretKey
+// Verifier 购买凭证校验器
+type Verifier interface {
+	VerifyPurchase(ctx context.Context, p Purchase) (*Receipt, error)
 }
 
 var (
-	statusPrefix      = []byte(`"status":`)
-	productPrefix     = []byte(`"product_id":`)
-	transactionPrefix = []byte(`"original_transaction_id":`)
+	_ Verifier = (*AppleVerifier)(nil)
+	_ Verifier = (*GoogleVerifier)(nil)
+	_ Verifier = (*HuaweiVerifier)(nil)
+	_ Verifier = (*AppStoreClient)(nil)
 )
 
-// Verify 验证票据是否合法
-func (v *Verifier) Verify(receiptData string, skipSandbox bool) (productId, transactionId string, err error) {
+// StatusError 商店接口返回的非成功状态
+type StatusError struct {
+	URL    string
+	Status int
+	Body   string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf(`iap: %s status %d: %s`, e.URL, e.Status, e.Body)
+}
+
+// defaultClient 未设置Client时使用的http客户端
+var defaultClient = &http.Client{Timeout: time.Second * 15}
+
+// doJSON 发送请求并以JSON解码响应
+// body为url.Values时以表单发送，否则以JSON发送
+func doJSON(ctx context.Context, client *http.Client, method, u string, header http.Header, body, v interface{}) error {
 	var (
-		url         = backendVerifyUrl
-		requestData = strings.Join([]string{
-			`{"receipt-data":"`, receiptData, `","password":"`, v.sharedSecretKey, `"}`,
-		}, "")
-		resp *http.Response
-		data []byte
+		rd  io.Reader
+		typ string
 	)
-
-	for i := 0; i < 2; i++ {
-		resp, err = http.Post(url, `application/x-www-form-urlencoded`, bytes.NewReader([]byte(requestData)))
-		if err != nil {
-			return
-		}
-		data, err = ioutil.ReadAll(resp.Body)
-		resp.Body.Close()
+	switch b := body.(type) {
+	case nil:
+	case url.Values:
+		rd, typ = strings.NewReader(b.Encode()), `application/x-www-form-urlencoded`
+	default:
+		data, err := json.Marshal(b)
 		if err != nil {
-			return
-		}
-		status, ok := v.propertyValue(data, statusPrefix)
-		if !ok {
-			err = errOthers
-			return
-		}
-		switch xutils.ParseI32(status, -1) {
-		default:
-			err = errOthers
-			return
-		case respCodeSuccess:
-			productId, _ = v.propertyValue(data, productPrefix)
-			transactionId, _ = v.propertyValue(data, transactionPrefix)
-			return
-		case respCode21000:
-			err = err21000
-			return
-		case respCode21002:
-			err = err21002
-			return
-		case respCode21003:
-			err = err21003
-			return
-		case respCode21004:
-			err = err21004
-			return
-		case respCode21005:
-			err = err21005
-			return
-		case respCode21006:
-			err = err21006
-			return
-		case respCode21007:
-			if skipSandbox {
-				err = err21007
-				return
-			}
-			url = sandboxVerifyUrl
-		case respCode21008:
-			err = err21008
-			return
-		case respCode21010:
-			err = err21010
-			return
+			return err
 		}
+		rd, typ = bytes.NewReader(data), `application/json; charset=utf-8`
 	}
-	return
-}
 
-// propertyValue 获取对应的JSON值
-func (v *Verifier) propertyValue(data, prefix []byte) (s string, ok bool) {
-	i := bytes.Index(data, prefix)
-	if i < 0 {
-		return
+	req, err := http.NewRequestWithContext(ctx, method, u, rd)
+	if err != nil {
+		return err
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	if typ != "" {
+		req.Header.Set(`Content-Type`, typ)
+	}
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
 	}
-	data = data[i+len(prefix):]
-	i = bytes.IndexByte(data, ',')
-	if i < 0 {
-		i = bytes.IndexByte(data, '}')
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{URL: u, Status: resp.StatusCode, Body: string(data)}
 	}
-	if i < 0 {
-		i = len(data)
+	return json.Unmarshal(data, v)
+}
+
+// accessToken 带有效期的访问令牌
+type accessToken struct {
+	token   string
+	expired time.Time
+}
+
+// valid 令牌是否可用(提前一分钟刷新)
+func (t *accessToken) valid() bool {
+	return t.token != "" && time.Now().Add(time.Minute).Before(t.expired)
+}
+
+// msTime 毫秒时间戳(数字或字符串)转换为时间，0为零值
+func msTime(v json.Number) time.Time {
+	ms, _ := strconv.ParseInt(string(v), 10, 64)
+	return unixMilli(ms)
+}
+
+// unixMilli 毫秒时间戳转换为时间，0为零值
+func unixMilli(ms int64) time.Time {
+	if ms <= 0 {
+		return time.Time{}
 	}
-	s, ok = string(bytes.Trim(bytes.TrimSpace(data[:i]), `"`)), true
-	return
+	return time.UnixMilli(ms)
 }
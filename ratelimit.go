@@ -0,0 +1,131 @@
+package micro
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/micro/packet"
+	"github.com/micro/xutils"
+)
+
+// 限流的维度
+const (
+	limitRemote = iota
+	limitUID
+	limitAPI
+
+	limitCount
+)
+
+// limitScopes 限流维度的名称(监控数据)
+var limitScopes = [limitCount]string{`remote`, `uid`, `api`}
+
+// limiter 令牌桶限流器
+// 按远端地址、UID及单个接口分别限制每秒请求数
+type limiter struct {
+	chunks [chunkSize]struct {
+		sync.Mutex
+		m     map[string]rateBucket
+		swept int64
+	}
+
+	// 被限流的请求数
+	limited [limitCount]uint64
+}
+
+// rateBucket 令牌桶
+type rateBucket struct {
+	tokens float64
+	last   int64
+}
+
+// Init 初始化
+func (l *limiter) Init() {
+	for i := 0; i < chunkSize; i++ {
+		l.chunks[i].m = make(map[string]rateBucket, 256)
+	}
+}
+
+// Allow 请求是否允许通过
+// 有UID时单个接口的限制按UID计算，否则按远端地址计算
+func (l *limiter) Allow(remote, uid, api string) bool {
+	now := time.Now().UnixNano()
+
+	if rate := env.config.RateRemote; rate > 0 && remote != "" {
+		if !l.take("r:"+remote, rate, now) {
+			atomic.AddUint64(&l.limited[limitRemote], 1)
+			return false
+		}
+	}
+	if rate := env.config.RateUID; rate > 0 && uid != "" {
+		if !l.take("u:"+uid, rate, now) {
+			atomic.AddUint64(&l.limited[limitUID], 1)
+			return false
+		}
+	}
+	if rate := env.config.RateAPIs[api]; rate > 0 {
+		key := uid
+		if key == "" {
+			key = remote
+		}
+		if !l.take("a:"+api+":"+key, rate, now) {
+			atomic.AddUint64(&l.limited[limitAPI], 1)
+			return false
+		}
+	}
+	return true
+}
+
+// take 从令牌桶中取出一个令牌
+func (l *limiter) take(key string, rate float64, now int64) bool {
+	const IDLE = int64(time.Minute)
+
+	burst := float64(env.config.RateBurst)
+	if burst <= 0 {
+		burst = math.Max(rate, 1)
+	}
+
+	c := &l.chunks[xutils.HashCode32(key)%chunkSize]
+	c.Lock()
+	b, ok := c.m[key]
+	if !ok {
+		b.tokens = burst
+	} else {
+		b.tokens = math.Min(burst, b.tokens+float64(now-b.last)/float64(time.Second)*rate)
+	}
+	b.last = now
+	ok = b.tokens >= 1
+	if ok {
+		b.tokens--
+	}
+	c.m[key] = b
+
+	// 清理空闲的令牌桶
+	if now-c.swept > IDLE {
+		for k, v := range c.m {
+			if now-v.last > IDLE {
+				delete(c.m, k)
+			}
+		}
+		c.swept = now
+	}
+	c.Unlock()
+	return ok
+}
+
+// Encode 以Prometheus文本格式输出限流数据
+func (l *limiter) Encode(pack *packet.Packet) {
+	pack.Write([]byte("# HELP micro_rate_limited_total Total number of requests rejected by rate limiting.\n"))
+	pack.Write([]byte("# TYPE micro_rate_limited_total counter\n"))
+	for i := 0; i < limitCount; i++ {
+		pack.Write([]byte(`micro_rate_limited_total{scope="`))
+		pack.Write([]byte(limitScopes[i]))
+		pack.WriteByte('"')
+		pack.WriteByte('}')
+		pack.WriteByte(' ')
+		pack.Write(xutils.ParseIntToBytes(int64(atomic.LoadUint64(&l.limited[i]))))
+		pack.WriteByte('\n')
+	}
+}
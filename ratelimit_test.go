@@ -0,0 +1,145 @@
+package micro
+
+import (
+	"net"
+	"strconv"
+	"testing"
+)
+
+func TestLimiterAllow(t *testing.T) {
+	saved := env.config
+	defer func() { env.config = saved }()
+
+	type call struct {
+		remote, uid, api string
+		allow            bool
+	}
+	tests := []struct {
+		name   string
+		remote float64
+		uid    float64
+		apis   map[string]float64
+		calls  []call
+	}{
+		{"unlimited", 0, 0, nil, []call{
+			{"1.1.1.1", "", "a", true},
+			{"1.1.1.1", "", "a", true},
+			{"1.1.1.1", "", "a", true},
+		}},
+		{"remote", 2, 0, nil, []call{
+			{"1.1.1.1", "u1", "a", true},
+			{"1.1.1.1", "u2", "b", true},
+			{"1.1.1.1", "u3", "c", false},
+			{"2.2.2.2", "u1", "a", true},
+		}},
+		{"uid", 0, 2, nil, []call{
+			{"1.1.1.1", "u1", "a", true},
+			{"2.2.2.2", "u1", "b", true},
+			{"3.3.3.3", "u1", "c", false},
+			{"3.3.3.3", "u2", "c", true},
+			{"3.3.3.3", "", "c", true},
+		}},
+		{"api by uid or remote", 0, 0, map[string]float64{"pay": 1}, []call{
+			{"1.1.1.1", "u1", "pay", true},
+			{"1.1.1.1", "u1", "pay", false},
+			{"1.1.1.1", "u2", "pay", true},
+			{"1.1.1.1", "", "pay", true},
+			{"1.1.1.1", "", "pay", false},
+			{"1.1.1.1", "u1", "other", true},
+		}},
+	}
+	for _, tt := range tests {
+		var l limiter
+		l.Init()
+		env.config.RateRemote, env.config.RateUID, env.config.RateAPIs = tt.remote, tt.uid, tt.apis
+		for i, c := range tt.calls {
+			if got := l.Allow(c.remote, c.uid, c.api); got != c.allow {
+				t.Errorf("%s: call %d Allow(%q, %q, %q) = %v; want %v", tt.name, i, c.remote, c.uid, c.api, got, c.allow)
+			}
+		}
+	}
+}
+
+func TestLimiterBurst(t *testing.T) {
+	saved := env.config
+	defer func() { env.config = saved }()
+
+	var l limiter
+	l.Init()
+	env.config.RateRemote, env.config.RateBurst = 1, 3
+	for i := 0; i < 3; i++ {
+		if !l.Allow("1.1.1.1", "", "a") {
+			t.Fatalf("call %d should be allowed within burst", i)
+		}
+	}
+	if l.Allow("1.1.1.1", "", "a") {
+		t.Fatal("call beyond burst should be limited")
+	}
+	if l.limited[limitRemote] != 1 {
+		t.Fatalf("limited remote = %d; want 1", l.limited[limitRemote])
+	}
+}
+
+// addrConn 只提供远端地址的连接
+type addrConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c addrConn) RemoteAddr() net.Addr { return c.addr }
+
+func TestClientRemote(t *testing.T) {
+	savedProxies := env.proxies
+	defer func() { env.proxies = savedProxies }()
+
+	tests := []struct {
+		name    string
+		proxies []string
+		conn    string
+		header  string
+		want    string
+	}{
+		{"no header", nil, "1.1.1.1:80", "", "1.1.1.1:80"},
+		{"no proxies", nil, "1.1.1.1:80", "9.9.9.9:1", "1.1.1.1:80"},
+		{"untrusted", []string{"10.0.0.1"}, "1.1.1.1:80", "9.9.9.9:1", "1.1.1.1:80"},
+		{"trusted ip", []string{"10.0.0.1"}, "10.0.0.1:80", "9.9.9.9:1", "9.9.9.9:1"},
+		{"trusted cidr", []string{"10.0.0.0/8"}, "10.2.3.4:80", "9.9.9.9:1", "9.9.9.9:1"},
+		{"trusted ipv6", []string{"::1"}, "[::1]:80", "9.9.9.9:1", "9.9.9.9:1"},
+		{"trusted no header", []string{"10.0.0.1"}, "10.0.0.1:80", "", "10.0.0.1:80"},
+		{"invalid proxy", []string{"proxy", "10.0.0.0/33"}, "10.0.0.1:80", "9.9.9.9:1", "10.0.0.1:80"},
+	}
+	for _, tt := range tests {
+		env.proxies = parseProxies(tt.proxies)
+		addr, _ := net.ResolveTCPAddr("tcp", tt.conn)
+		if got := clientRemote(addrConn{addr: addr}, tt.header); got != tt.want {
+			t.Errorf("%s: clientRemote = %q; want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLimiterForgedRemote(t *testing.T) {
+	saved, savedProxies := env.config, env.proxies
+	defer func() { env.config, env.proxies = saved, savedProxies }()
+
+	var l limiter
+	l.Init()
+	env.config.RateRemote, env.config.RateBurst = 1, 1
+	env.proxies = parseProxies([]string{"10.0.0.1"})
+
+	// 非可信代理的连接每次伪造不同的Remote-Addr，仍共用连接地址的令牌桶
+	addr, _ := net.ResolveTCPAddr("tcp", "1.1.1.1:5000")
+	conn := addrConn{addr: addr}
+	for i := 0; i < 10; i++ {
+		remote := remoteHost(clientRemote(conn, "9.9.9."+strconv.Itoa(i)+":1"))
+		if got := l.Allow(remote, "", "a"); got != (i == 0) {
+			t.Fatalf("call %d with forged header allowed = %v", i, got)
+		}
+	}
+	n := 0
+	for i := range l.chunks {
+		n += len(l.chunks[i].m)
+	}
+	if n != 1 {
+		t.Fatalf("forged headers created %d buckets; want 1", n)
+	}
+}
@@ -0,0 +1,103 @@
+package micro
+
+import (
+	nethttp "net/http"
+	"strconv"
+	"strings"
+)
+
+// HTTPStatus 第三方接口只响应状态码(无消息体)
+// 如回调处理失败时返回HTTPStatus(500)，第三方会稍后重试
+type HTTPStatus int
+
+// statusLine 响应状态行
+func (s HTTPStatus) statusLine() []byte {
+	return []byte("HTTP/1.1 " + strconv.Itoa(int(s)) + " " + nethttp.StatusText(int(s)) + "\r\n")
+}
+
+// route http路由
+type route struct {
+	method   string   // 请求方法，为空匹配所有方法
+	segments []string // 路径段，":name"匹配单段，"*name"匹配剩余路径
+	api      *bisAPI
+}
+
+// match 匹配请求路径，返回路径参数及匹配的静态段数
+func (r *route) match(method string, segments []string) (map[string]string, int, bool) {
+	if r.method != "" && r.method != method {
+		return nil, 0, false
+	}
+
+	var (
+		params map[string]string
+		static int
+	)
+	for i, seg := range r.segments {
+		switch {
+		case seg != "" && seg[0] == '*':
+			if params == nil {
+				params = make(map[string]string, 2)
+			}
+			params[seg[1:]] = strings.Join(segments[i:], "/")
+			return params, static, true
+		case i >= len(segments):
+			return nil, 0, false
+		case seg != "" && seg[0] == ':':
+			if segments[i] == "" {
+				return nil, 0, false
+			}
+			if params == nil {
+				params = make(map[string]string, 2)
+			}
+			params[seg[1:]] = segments[i]
+		case seg == segments[i]:
+			static++
+		default:
+			return nil, 0, false
+		}
+	}
+	if len(segments) != len(r.segments) {
+		return nil, 0, false
+	}
+	return params, static, true
+}
+
+// Route 按请求方法及路径注册http接口，用于第三方回调等
+// pattern以'/'分隔，":name"匹配单段路径，"*name"匹配剩余路径，参数通过HTTPDpo.Param获取
+// method为空时匹配所有请求方法；多个路由匹配时静态段多的优先
+func Route(method, pattern string, df bisDpo, opts ...Option) {
+	method = strings.ToUpper(method)
+	pattern = strings.Trim(pattern, "/")
+	name := pattern
+	if method != "" {
+		name = method + " " + pattern
+	}
+	env.routes = append(env.routes, &route{
+		method:   method,
+		segments: strings.Split(pattern, "/"),
+		api:      newBisAPI(`route`, name, df, opts),
+	})
+}
+
+// findRoute 查找路由
+func findRoute(method, path string) (*bisAPI, map[string]string, bool) {
+	if len(env.routes) == 0 {
+		return nil, nil, false
+	}
+
+	var (
+		found  *route
+		params map[string]string
+		best   = -1
+	)
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for _, r := range env.routes {
+		if ps, static, ok := r.match(method, segments); ok && static > best {
+			found, params, best = r, ps, static
+		}
+	}
+	if found == nil {
+		return nil, nil, false
+	}
+	return found.api, params, true
+}
@@ -0,0 +1,57 @@
+package micro
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRouteMatch(t *testing.T) {
+	tests := []struct {
+		method  string
+		pattern string
+		reqM    string
+		path    string
+		params  map[string]string
+		static  int
+		ok      bool
+	}{
+		{"POST", "pay/notify", "POST", "pay/notify", nil, 2, true},
+		{"POST", "pay/notify", "GET", "pay/notify", nil, 0, false},
+		{"", "pay/notify", "GET", "pay/notify", nil, 2, true},
+		{"", "pay/notify", "", "pay/notify/x", nil, 0, false},
+		{"", "pay/notify", "", "pay", nil, 0, false},
+		{"", "pay/:channel", "", "pay/apple", map[string]string{"channel": "apple"}, 1, true},
+		{"", "pay/:channel", "", "pay/", nil, 0, false},
+		{"", "pay/:channel/:id", "", "pay/apple", nil, 0, false},
+		{"", "files/*path", "", "files/a/b/c", map[string]string{"path": "a/b/c"}, 1, true},
+		{"", "files/*path", "", "files", map[string]string{"path": ""}, 1, true},
+		{"", "files/*path", "", "other/a", nil, 0, false},
+	}
+	for _, tt := range tests {
+		r := &route{method: tt.method, segments: strings.Split(tt.pattern, "/")}
+		params, static, ok := r.match(tt.reqM, strings.Split(tt.path, "/"))
+		if ok != tt.ok || static != tt.static || !reflect.DeepEqual(params, tt.params) {
+			t.Errorf("match(%s %q, %s %q) = %v, %d, %v; want %v, %d, %v",
+				tt.method, tt.pattern, tt.reqM, tt.path, params, static, ok, tt.params, tt.static, tt.ok)
+		}
+	}
+}
+
+func TestFindRoutePriority(t *testing.T) {
+	saved := env.routes
+	defer func() { env.routes = saved }()
+
+	env.routes = []*route{
+		{segments: strings.Split("pay/:channel", "/"), api: &bisAPI{}},
+		{segments: strings.Split("pay/apple", "/"), api: &bisAPI{}},
+	}
+	b, params, ok := findRoute("GET", "/pay/apple/")
+	if !ok || b != env.routes[1].api || params != nil {
+		t.Fatalf("static route should win, got %v %v %v", b, params, ok)
+	}
+	b, params, ok = findRoute("GET", "pay/google")
+	if !ok || b != env.routes[0].api || params["channel"] != "google" {
+		t.Fatalf("param route should match, got %v %v %v", b, params, ok)
+	}
+}
@@ -0,0 +1,247 @@
+package micro
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// apiSchema 业务接口描述
+type apiSchema struct {
+	Name     string      `json:"name"`
+	Kind     string      `json:"kind"`
+	Public   bool        `json:"public,omitempty"`
+	Roles    []string    `json:"roles,omitempty"`
+	Request  interface{} `json:"request"`
+	Response interface{} `json:"response"`
+}
+
+// schemaDoc 业务接口描述文档
+type schemaDoc struct {
+	Schema      string                 `json:"$schema"`
+	Title       string                 `json:"title"`
+	APIs        []apiSchema            `json:"apis"`
+	Definitions map[string]interface{} `json:"definitions"`
+}
+
+// exportSchema 导出业务接口描述及客户端代码
+// 加载配置及初始化会话后调用onStartup收集注册的业务接口，不初始化存储，不启动监听及服务注册
+func exportSchema(onStartup func(), dir string) error {
+	prepareService(onStartup, false)
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	b := newSchemaBuilder()
+	data, err := json.MarshalIndent(b.build(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "schema.json"), data, os.ModePerm); err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "api.ts"), b.typescript(), os.ModePerm); err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "api.lua"), b.lua(), os.ModePerm); err != nil {
+		return err
+	}
+
+	Logf("schema exported to %s", dir)
+	return nil
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// schemaBuilder 通过反射生成接口描述
+type schemaBuilder struct {
+	defs  map[string]interface{}
+	names map[reflect.Type]string
+	order []reflect.Type
+}
+
+// schemaField 结构体字段(按json编码规则)
+type schemaField struct {
+	name     string
+	typ      reflect.Type
+	optional bool
+}
+
+// newSchemaBuilder 创建生成器
+func newSchemaBuilder() *schemaBuilder {
+	return &schemaBuilder{
+		defs:  make(map[string]interface{}, 64),
+		names: make(map[reflect.Type]string, 64),
+	}
+}
+
+// build 生成描述文档
+func (b *schemaBuilder) build() *schemaDoc {
+	doc := &schemaDoc{
+		Schema:      "http://json-schema.org/draft-07/schema#",
+		Title:       env.config.Name,
+		Definitions: b.defs,
+	}
+	for _, api := range sortedAPIs(env.bis) {
+		doc.APIs = append(doc.APIs, b.apiOf(api))
+	}
+	for _, api := range sortedAPIs(env.rps) {
+		doc.APIs = append(doc.APIs, b.apiOf(api))
+	}
+	return doc
+}
+
+// sortedAPIs 按名称排序的业务接口
+func sortedAPIs(apis map[string]*bisAPI) []*bisAPI {
+	ret := make([]*bisAPI, 0, len(apis))
+	for _, api := range apis {
+		ret = append(ret, api)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].name < ret[j].name
+	})
+	return ret
+}
+
+// apiOf 单个业务接口的描述
+func (b *schemaBuilder) apiOf(api *bisAPI) apiSchema {
+	as := apiSchema{
+		Name:     api.name,
+		Kind:     api.kind,
+		Public:   api.opts.public,
+		Roles:    api.opts.roles,
+		Request:  map[string]interface{}{},
+		Response: map[string]interface{}{},
+	}
+	if api.opts.req != nil {
+		as.Request = b.schemaOf(api.opts.req)
+	}
+	if api.opts.resp != nil {
+		as.Response = b.schemaOf(api.opts.resp)
+	}
+	return as
+}
+
+// schemaOf 类型的JSON Schema
+func (b *schemaBuilder) schemaOf(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	if t.Implements(marshalerType) || reflect.PtrTo(t).Implements(marshalerType) {
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]interface{}{"type": "array", "items": b.schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.objectOf(t)
+		}
+		return map[string]interface{}{"$ref": "#/definitions/" + b.define(t)}
+	}
+	return map[string]interface{}{}
+}
+
+// define 登记命名结构体
+func (b *schemaBuilder) define(t reflect.Type) string {
+	if name, ok := b.names[t]; ok {
+		return name
+	}
+	name := t.Name()
+	if _, ok := b.defs[name]; ok {
+		name = strings.Title(path.Base(t.PkgPath())) + name
+	}
+	b.names[t] = name
+	b.defs[name] = nil
+	b.order = append(b.order, t)
+	b.defs[name] = b.objectOf(t)
+	return name
+}
+
+// objectOf 结构体的JSON Schema
+func (b *schemaBuilder) objectOf(t reflect.Type) map[string]interface{} {
+	props := make(map[string]interface{}, t.NumField())
+	required := make([]string, 0, t.NumField())
+	for _, f := range schemaFields(t) {
+		props[f.name] = b.schemaOf(f.typ)
+		if !f.optional {
+			required = append(required, f.name)
+		}
+	}
+	obj := map[string]interface{}{
+		"type":       "object",
+		"properties": props,
+	}
+	if len(required) > 0 {
+		obj["required"] = required
+	}
+	return obj
+}
+
+// schemaFields 结构体参与json编码的字段
+func schemaFields(t reflect.Type) []schemaField {
+	fields := make([]schemaField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if i := strings.IndexByte(tag, ','); i >= 0 {
+			name, opts = tag[:i], tag[i+1:]
+		}
+
+		// 匿名结构体的字段提升到外层
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				fields = append(fields, schemaFields(ft)...)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, schemaField{
+			name:     name,
+			typ:      f.Type,
+			optional: strings.Contains(opts, "omitempty") || f.Type.Kind() == reflect.Ptr,
+		})
+	}
+	return fields
+}
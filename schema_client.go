@@ -0,0 +1,332 @@
+package micro
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// identRegexp 可直接作为方法名的接口名称
+var identRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// tsRuntime TypeScript客户端的传输层
+// http: POST请求，Api头域指定接口，Session(或UID)头域指定用户，消息体为URL编码的JSON
+// websocket: 文本帧 api{json}，响应按接口名称依次匹配，其余为推送
+const tsRuntime = `export class ApiError extends Error {
+  constructor(public code: string) {
+    super(code);
+  }
+}
+
+export interface Transport {
+  call(api: string, req: unknown): Promise<unknown>;
+}
+
+// decodeResult 解析响应数据，{"ErrCode":"..."}转换为ApiError
+function decodeResult(body: string): unknown {
+  if (!body) return undefined;
+  const data = JSON.parse(body);
+  if (data && typeof data === "object" && typeof data.ErrCode === "string" && Object.keys(data).length === 1) {
+    throw new ApiError(data.ErrCode);
+  }
+  return data;
+}
+
+export class HttpTransport implements Transport {
+  uid = "";
+  session = "";
+
+  constructor(public url: string) {}
+
+  async call(api: string, req: unknown): Promise<unknown> {
+    const headers: Record<string, string> = { Api: api };
+    if (this.uid) headers.UID = this.uid;
+    if (this.session) headers.Session = this.session;
+    const resp = await fetch(this.url, {
+      method: "POST",
+      headers,
+      body: encodeURIComponent(JSON.stringify(req ?? {})),
+    });
+    const sid = resp.headers.get("Session");
+    if (sid) this.session = sid;
+    return decodeResult(await resp.text());
+  }
+}
+
+type Pending = { resolve: (v: unknown) => void; reject: (e: unknown) => void };
+
+export class WebSocketTransport implements Transport {
+  onPush?: (api: string, data: unknown) => void;
+
+  private ws: WebSocket;
+  private pending = new Map<string, Pending[]>();
+
+  constructor(url: string, token?: string) {
+    this.ws = token ? new WebSocket(url, ["json", token]) : new WebSocket(url);
+    this.ws.onmessage = (ev) => this.receive(String(ev.data));
+    this.ws.onclose = () => this.reset(new ApiError("Closed"));
+  }
+
+  ready(): Promise<void> {
+    if (this.ws.readyState === WebSocket.OPEN) return Promise.resolve();
+    return new Promise((resolve, reject) => {
+      this.ws.addEventListener("open", () => resolve(), { once: true });
+      this.ws.addEventListener("error", (e) => reject(e), { once: true });
+    });
+  }
+
+  close(): void {
+    this.ws.close();
+  }
+
+  call(api: string, req: unknown): Promise<unknown> {
+    return new Promise((resolve, reject) => {
+      let q = this.pending.get(api);
+      if (!q) this.pending.set(api, (q = []));
+      q.push({ resolve, reject });
+      this.ws.send(api + JSON.stringify(req ?? {}));
+    });
+  }
+
+  private receive(msg: string): void {
+    const i = msg.search(/[{["]/);
+    const api = i < 0 ? msg : msg.slice(0, i);
+    const body = i < 0 ? "" : msg.slice(i);
+    const p = this.pending.get(api)?.shift();
+    if (!p) {
+      this.onPush?.(api, body ? JSON.parse(body) : undefined);
+      return;
+    }
+    try {
+      p.resolve(decodeResult(body));
+    } catch (e) {
+      p.reject(e);
+    }
+  }
+
+  private reset(err: unknown): void {
+    for (const q of this.pending.values()) {
+      for (const p of q) p.reject(err);
+    }
+    this.pending.clear();
+  }
+}
+`
+
+// luaRuntime Lua客户端的公共部分
+// transport(api, body, callback(body))由引擎层实现(http或websocket)
+const luaRuntime = `local Api = {}
+Api.__index = Api
+
+--- 创建接口对象
+---@param transport fun(api: string, body: string, callback: fun(body: string))
+---@param json table 提供encode/decode的JSON库(如cjson)
+function Api.new(transport, json)
+    return setmetatable({ transport = transport, json = json }, Api)
+end
+
+--- websocket消息编码: api{json}
+function Api.encode(api, body)
+    return api .. body
+end
+
+--- websocket消息解码，返回接口名称及JSON
+function Api.decode(msg)
+    local i = string.find(msg, '[{%["]')
+    if i == nil then
+        return msg, ""
+    end
+    return string.sub(msg, 1, i - 1), string.sub(msg, i)
+end
+
+--- 调用接口，callback(resp, errCode)
+function Api:call(api, req, callback)
+    self.transport(api, self.json.encode(req or {}), function(body)
+        if callback == nil then
+            return
+        end
+        if body == nil or body == "" then
+            callback(nil, nil)
+            return
+        end
+        local data = self.json.decode(body)
+        if type(data) == "table" and type(data.ErrCode) == "string" then
+            local n = 0
+            for _ in pairs(data) do
+                n = n + 1
+            end
+            if n == 1 then
+                callback(nil, data.ErrCode)
+                return
+            end
+        end
+        callback(data, nil)
+    end)
+end
+`
+
+// typescript 生成TypeScript客户端代码
+func (b *schemaBuilder) typescript() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by micro schema. DO NOT EDIT.\n\n")
+
+	for _, t := range b.order {
+		fmt.Fprintf(&buf, "export interface %s {\n", b.names[t])
+		for _, f := range schemaFields(t) {
+			fmt.Fprintf(&buf, "  %s%s: %s;\n", tsKey(f.name), tsOptional(f.optional), b.tsType(f.typ))
+		}
+		buf.WriteString("}\n\n")
+	}
+
+	buf.WriteString(tsRuntime)
+	buf.WriteString("\nexport class Api {\n  constructor(public transport: Transport) {}\n")
+	for _, api := range sortedAPIs(env.bis) {
+		req, resp := "any", "any"
+		if api.opts.req != nil {
+			req = b.tsType(api.opts.req)
+		}
+		if api.opts.resp != nil {
+			resp = b.tsType(api.opts.resp)
+		}
+		fmt.Fprintf(&buf, "\n  %s(req: %s): Promise<%s> {\n", tsKey(api.name), req, resp)
+		fmt.Fprintf(&buf, "    return this.transport.call(%q, req) as Promise<%s>;\n  }\n", api.name, resp)
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
+// tsType 类型对应的TypeScript类型
+func (b *schemaBuilder) tsType(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return "string"
+	}
+	if t.Implements(marshalerType) || reflect.PtrTo(t).Implements(marshalerType) {
+		return "any"
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "string"
+		}
+		return b.tsType(t.Elem()) + "[]"
+	case reflect.Map:
+		return "Record<string, " + b.tsType(t.Elem()) + ">"
+	case reflect.Struct:
+		if t.Name() != "" {
+			return b.define(t)
+		}
+		fields := schemaFields(t)
+		ss := make([]string, 0, len(fields))
+		for _, f := range fields {
+			ss = append(ss, tsKey(f.name)+tsOptional(f.optional)+": "+b.tsType(f.typ))
+		}
+		return "{ " + strings.Join(ss, "; ") + " }"
+	}
+	return "any"
+}
+
+// tsKey 属性或方法名称
+func tsKey(name string) string {
+	if identRegexp.MatchString(name) {
+		return name
+	}
+	return fmt.Sprintf("%q", name)
+}
+
+// tsOptional 可选属性标记
+func tsOptional(optional bool) string {
+	if optional {
+		return "?"
+	}
+	return ""
+}
+
+// lua 生成Lua客户端代码(EmmyLua注解)
+func (b *schemaBuilder) lua() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("-- Code generated by micro schema. DO NOT EDIT.\n\n")
+
+	for _, t := range b.order {
+		fmt.Fprintf(&buf, "---@class %s\n", b.names[t])
+		for _, f := range schemaFields(t) {
+			typ := b.luaType(f.typ)
+			if f.optional {
+				typ += "|nil"
+			}
+			fmt.Fprintf(&buf, "---@field %s %s\n", f.name, typ)
+		}
+		buf.WriteString("\n")
+	}
+
+	buf.WriteString(luaRuntime)
+	for _, api := range sortedAPIs(env.bis) {
+		req, resp := "table", "any"
+		if api.opts.req != nil {
+			req = b.luaType(api.opts.req)
+		}
+		if api.opts.resp != nil {
+			resp = b.luaType(api.opts.resp)
+		}
+		fmt.Fprintf(&buf, "\n---@param req %s\n", req)
+		fmt.Fprintf(&buf, "---@param callback fun(resp: %s, errCode: string)\n", resp)
+		if identRegexp.MatchString(api.name) {
+			fmt.Fprintf(&buf, "function Api:%s(req, callback)\n", api.name)
+		} else {
+			fmt.Fprintf(&buf, "Api[%q] = function(self, req, callback)\n", api.name)
+		}
+		fmt.Fprintf(&buf, "    self:call(%q, req, callback)\nend\n", api.name)
+	}
+	buf.WriteString("\nreturn Api\n")
+	return buf.Bytes()
+}
+
+// luaType 类型对应的Lua注解类型
+func (b *schemaBuilder) luaType(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return "string"
+	}
+	if t.Implements(marshalerType) || reflect.PtrTo(t).Implements(marshalerType) {
+		return "any"
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "string"
+		}
+		return b.luaType(t.Elem()) + "[]"
+	case reflect.Map:
+		return "table<string, " + b.luaType(t.Elem()) + ">"
+	case reflect.Struct:
+		if t.Name() != "" {
+			return b.define(t)
+		}
+		return "table"
+	}
+	return "any"
+}
@@ -0,0 +1,246 @@
+package micro
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/micro/packet"
+)
+
+// 会话缓存中可保存的数据类型
+// 其他类型的数据只在单次请求中有效
+const (
+	sesString = iota + 1
+	sesI32
+	sesI64
+	sesU32
+	sesU64
+	sesF32
+	sesF64
+	sesBool
+	sesStrings
+)
+
+// sessionExpired 未配置Expired时会话的有效期
+const sessionExpired = time.Hour * 24
+
+// SessionInfo http会话信息
+type SessionInfo struct {
+	ID        string // 会话标识
+	UID       string // 用户标识
+	CreatedAt int64  // 创建时间(Unix秒)
+}
+
+// session http会话
+type session struct {
+	SessionInfo
+
+	active int64
+	cache  dpoCache
+}
+
+// Encode 编码
+func (s *session) Encode(pack *packet.Packet) {
+	pack.WriteString(s.ID)
+	pack.WriteString(s.UID)
+	pack.WriteI64(s.CreatedAt)
+	pack.WriteI64(s.active)
+
+	// 缓存数据
+	n := 0
+	for _, v := range s.cache {
+		if sessionValueType(v) != 0 {
+			n++
+		}
+	}
+	pack.WriteU32(uint32(n))
+	for key, v := range s.cache {
+		typ := sessionValueType(v)
+		if typ == 0 {
+			continue
+		}
+		pack.WriteString(key)
+		pack.WriteByte(typ)
+		switch typ {
+		case sesString:
+			pack.WriteString(v.(string))
+		case sesI32:
+			pack.WriteI32(v.(int32))
+		case sesI64:
+			pack.WriteI64(v.(int64))
+		case sesU32:
+			pack.WriteU32(v.(uint32))
+		case sesU64:
+			pack.WriteU64(v.(uint64))
+		case sesF32:
+			pack.WriteF32(v.(float32))
+		case sesF64:
+			pack.WriteF64(v.(float64))
+		case sesBool:
+			pack.WriteBool(v.(bool))
+		case sesStrings:
+			pack.WriteStrings(v.([]string))
+		}
+	}
+}
+
+// Decode 解码
+func (s *session) Decode(pack *packet.Packet) {
+	s.ID = pack.ReadString()
+	s.UID = pack.ReadString()
+	s.CreatedAt = pack.ReadI64()
+	s.active = pack.ReadI64()
+
+	// 缓存数据
+	n := pack.ReadU32()
+	for i := uint32(0); i < n; i++ {
+		key := pack.ReadString()
+		typ, _ := pack.ReadByte()
+		var v interface{}
+		switch typ {
+		case sesString:
+			v = pack.ReadString()
+		case sesI32:
+			v = pack.ReadI32()
+		case sesI64:
+			v = pack.ReadI64()
+		case sesU32:
+			v = pack.ReadU32()
+		case sesU64:
+			v = pack.ReadU64()
+		case sesF32:
+			v = pack.ReadF32()
+		case sesF64:
+			v = pack.ReadF64()
+		case sesBool:
+			v = pack.ReadBool()
+		case sesStrings:
+			v = pack.ReadStrings()
+		}
+		if s.cache != nil {
+			s.cache[key] = v
+		}
+	}
+}
+
+// sessionValueType 缓存数据的类型，0为不可保存
+func sessionValueType(v interface{}) byte {
+	switch v.(type) {
+	case string:
+		return sesString
+	case int32:
+		return sesI32
+	case int64:
+		return sesI64
+	case uint32:
+		return sesU32
+	case uint64:
+		return sesU64
+	case float32:
+		return sesF32
+	case float64:
+		return sesF64
+	case bool:
+		return sesBool
+	case []string:
+		return sesStrings
+	}
+	return 0
+}
+
+// sessions http会话存储
+// 会话以Session头域标识，每次访问都会刷新有效期
+type sessions struct {
+	cache   packet.Cache
+	expired int64
+}
+
+// Init 初始化
+func (s *sessions) Init(expired time.Duration) {
+	if expired <= 0 {
+		expired = sessionExpired
+	}
+	s.cache = packet.NewCache(expired, nil)
+	s.expired = int64(expired / time.Second)
+}
+
+// Load 加载会话，缓存数据写入cac
+func (s *sessions) Load(sid string, cac dpoCache) (*session, bool) {
+	ses := &session{cache: cac}
+	if !s.cache.Has(sid) || !s.cache.Load(ses, sid) {
+		return nil, false
+	}
+	// 缓存只定期清理过期数据，这里需要再次确认
+	if time.Now().Unix()-ses.active > s.expired {
+		s.cache.Del(sid)
+		return nil, false
+	}
+	return ses, true
+}
+
+// Create 创建会话
+func (s *sessions) Create(uid string, cac dpoCache) *session {
+	var id [16]byte
+	rand.Read(id[:])
+
+	ses := &session{
+		SessionInfo: SessionInfo{
+			ID:        hex.EncodeToString(id[:]),
+			UID:       uid,
+			CreatedAt: time.Now().Unix(),
+		},
+		cache: cac,
+	}
+	ses.active = ses.CreatedAt
+	s.cache.Put(ses.ID, ses)
+	return ses
+}
+
+// Save 保存会话并刷新有效期
+func (s *sessions) Save(ses *session) {
+	ses.active = time.Now().Unix()
+	s.cache.Put(ses.ID, ses)
+}
+
+// Del 删除会话
+func (s *sessions) Del(sid string) {
+	s.cache.Del(sid)
+}
+
+// Walk 迭代所有会话
+func (s *sessions) Walk(f func(info SessionInfo)) {
+	var ses session
+	s.cache.WalkCache(&ses, func() bool {
+		f(ses.SessionInfo)
+		return false
+	})
+}
+
+// Sessions 所有http会话
+func Sessions() []SessionInfo {
+	var infos []SessionInfo
+	env.sessions.Walk(func(info SessionInfo) {
+		infos = append(infos, info)
+	})
+	return infos
+}
+
+// KickSession 删除指定的http会话
+func KickSession(sid string) {
+	env.sessions.Del(sid)
+}
+
+// KickUserSessions 删除用户的所有http会话
+func KickUserSessions(uid string) int {
+	var sids []string
+	env.sessions.Walk(func(info SessionInfo) {
+		if info.UID == uid {
+			sids = append(sids, info.ID)
+		}
+	})
+	for _, sid := range sids {
+		env.sessions.Del(sid)
+	}
+	return len(sids)
+}
@@ -60,6 +60,33 @@ func startupService(onStartup func()) error {
 
 // createService 创建服务
 func createService(onStartup func()) (net.Listener, error) {
+	prepareService(onStartup, true)
+
+	// 初始化处理器
+	env.chains = []chain{
+		&http{},
+		&env.rpc,
+		&websocket{},
+		&env.registry,
+		&closer{},
+		&uploader{},
+	}
+	for i := 0; i < len(env.chains); i++ {
+		env.chains[i].Init()
+	}
+	env.pusher.Init()
+
+	err := env.tls.Init()
+	if err != nil {
+		return nil, err
+	}
+	env.lsr, err = env.tls.Listen(env.config.Address)
+	return env.lsr, err
+}
+
+// prepareService 加载配置、初始化会话并调用外部初始化
+// 启动服务与导出接口描述共用；initStore为false时不连接数据库，也不执行DBSQLs
+func prepareService(onStartup func(), initStore bool) {
 	err := loadConfig()
 	if err != nil {
 		Debug("load config error: %v", err)
@@ -79,7 +106,7 @@ func createService(onStartup func()) (net.Listener, error) {
 
 	// 数据存储
 	userTableName := env.config.UserTabName
-	if env.config.DBResource != "" {
+	if initStore && env.config.DBResource != "" {
 		if !store.IsBackupOnErrorSetted() {
 			store.SetBackupOnError(func(SQL string, err error) {
 				Logf(">> SQL execute error:\n[%s]\n%v", SQL, err)
@@ -95,32 +122,18 @@ func createService(onStartup func()) (net.Listener, error) {
 	}
 	userExpired := time.Duration(env.config.Expired) * time.Second
 	env.userCache = packet.NewCache(userExpired, store.NewSaver(userTableName))
+	env.sessions.Init(userExpired)
 
 	// 调用外部初始化
 	if onStartup != nil {
 		onStartup()
 	}
-
-	// 初始化处理器
-	env.chains = []chain{
-		&http{},
-		&env.rpc,
-		&websocket{},
-		&env.registry,
-		&closer{},
-		&uploader{},
-	}
-	for i := 0; i < len(env.chains); i++ {
-		env.chains[i].Init()
-	}
-
-	env.lsr, err = net.Listen("tcp", env.config.Address)
-	return env.lsr, err
 }
 
 // destroyService 销毁服务
 func destroyService() {
 	env.lsr.Close()
+	drainService()
 	for _, closeFunc := range env.closeFunc {
 		closeFunc()
 	}
@@ -130,62 +143,39 @@ func destroyService() {
 	store.Close()
 }
 
-type bisDpo func(dpo Dpo) (resp interface{}, errCode string)
+type bisDpo = Handler
 
 // Register 注册业务接口
-func Register(api string, df bisDpo) {
-	const errUNKNOWN = `Unknown`
-
-	env.bis[api] = func(dpo Dpo) (resp interface{}, errCode string) {
-		defer func() {
-			err := recover()
-			if err == nil {
-				return
-			}
-			pack := packet.New(1024)
-			buf := pack.Allocate(1024)
-			buf = buf[:runtime.Stack(buf, false)]
-			Debug("\nhandle [%s] error: %v\n%s\n\n", api, err, buf)
-			packet.Free(pack)
-			errCode = errUNKNOWN
-		}()
-		resp, errCode = df(dpo)
-		return
-	}
+func Register(api string, df bisDpo, opts ...Option) {
+	env.bis[api] = newBisAPI(`handle`, api, df, opts)
+}
+
+// RegisterPublic 注册允许未登录访问的业务接口
+func RegisterPublic(api string, df bisDpo, opts ...Option) {
+	Register(api, df, append(opts, Public())...)
 }
 
 // findBis 查找业务
 func findBis(api string) (bisDpo, bool) {
-	df, ok := env.bis[api]
-	return df, ok
+	b, ok := env.bis[api]
+	if !ok {
+		return nil, false
+	}
+	return b.call, true
 }
 
 // RegisterRPC 注册业务接口RPC
-func RegisterRPC(api string, df bisDpo) {
-	const errUNKNOWN = `Unknown`
-
-	env.rps[api] = func(dpo Dpo) (resp interface{}, errCode string) {
-		defer func() {
-			err := recover()
-			if err == nil {
-				return
-			}
-			pack := packet.New(1024)
-			buf := pack.Allocate(1024)
-			buf = buf[:runtime.Stack(buf, false)]
-			Debug("\nrpc [%s] error: %v\n%s\n\n", api, err, buf)
-			packet.Free(pack)
-			errCode = errUNKNOWN
-		}()
-		resp, errCode = df(dpo)
-		return
-	}
+func RegisterRPC(api string, df bisDpo, opts ...Option) {
+	env.rps[api] = newBisAPI(`rpc`, api, df, opts)
 }
 
 // findRps 查找RPC业务
 func findRps(api string) (bisDpo, bool) {
-	rp, ok := env.rps[api]
-	return rp, ok
+	b, ok := env.rps[api]
+	if !ok {
+		return nil, false
+	}
+	return b.call, true
 }
 
 // processConn 处理请求
@@ -202,6 +192,12 @@ func processConn(conn net.Conn) {
 	// 协议升级类型
 	upgrade := pack.HTTPHeaderValue(httpUpgrade)
 
+	// 服务间连接需要校验客户端证书
+	if !env.tls.Verified(conn, upgrade) {
+		packet.Free(pack)
+		return
+	}
+
 	// 处理请求
 	for i := 0; i < len(env.chains); i++ {
 		if env.chains[i].Handle(conn, upgrade, pack) {
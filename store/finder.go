@@ -93,3 +93,18 @@ func SetParam(sq, name string, value interface{}) string {
 	}
 	return strings.Replace(sq, name, "", 1)
 }
+
+// ExecuteRows 立即执行SQL，返回影响的行数
+func ExecuteRows(SQL string, args ...interface{}) (int64, error) {
+	env.RLock()
+	if env.db == nil {
+		env.RUnlock()
+		return 0, errNotInitialized
+	}
+	r, err := env.db.Exec(SQL, args...)
+	env.RUnlock()
+	if err != nil {
+		return 0, err
+	}
+	return r.RowsAffected()
+}
@@ -0,0 +1,74 @@
+package thirdparty
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"strings"
+
+	"github.com/micro"
+)
+
+// HMACVerifier 排序参数的HMAC签名校验器(微信支付等)
+// 待签名串为除签名外的排序参数，签名为十六进制文本(不区分大小写)
+type HMACVerifier struct {
+	Key  string
+	Hash func() hash.Hash
+
+	// 签名参数名称，为空时使用sign
+	Field string
+	// 不参与签名的参数，为空时排除sign
+	Exclude []string
+	// 待签名串末尾追加&key=密钥(微信支付)
+	AppendKey bool
+}
+
+// NewHMACMD5Verifier 创建HMAC-MD5签名校验器
+func NewHMACMD5Verifier(key string) *HMACVerifier {
+	return &HMACVerifier{Key: key, Hash: md5.New}
+}
+
+// NewHMACSHA256Verifier 创建HMAC-SHA256签名校验器
+func NewHMACSHA256Verifier(key string) *HMACVerifier {
+	return &HMACVerifier{Key: key, Hash: sha256.New}
+}
+
+// Verify 校验签名
+func (v *HMACVerifier) Verify(dpo micro.HTTPDpo) error {
+	params, err := Params(dpo)
+	if err != nil {
+		return err
+	}
+	field := v.Field
+	if field == "" {
+		field = SignField
+	}
+	exclude := v.Exclude
+	if len(exclude) == 0 {
+		exclude = []string{field}
+	}
+	return v.VerifyContent(SignContent(params, exclude...), params[field])
+}
+
+// Sign 计算待签名串的签名(大写十六进制)
+func (v *HMACVerifier) Sign(content string) string {
+	if v.AppendKey {
+		content += "&key=" + v.Key
+	}
+	mac := hmac.New(v.Hash, []byte(v.Key))
+	mac.Write([]byte(content))
+	return strings.ToUpper(hex.EncodeToString(mac.Sum(nil)))
+}
+
+// VerifyContent 校验待签名串的签名
+func (v *HMACVerifier) VerifyContent(content, sign string) error {
+	if sign == "" {
+		return ErrMissingSign
+	}
+	if !hmac.Equal([]byte(v.Sign(content)), []byte(strings.ToUpper(sign))) {
+		return ErrInvalidSign
+	}
+	return nil
+}
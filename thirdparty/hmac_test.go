@@ -0,0 +1,55 @@
+package thirdparty
+
+import (
+	"crypto/sha256"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHMACVerifier(t *testing.T) {
+	const content = "appid=wx1&body=test&nonce_str=abc"
+
+	tests := []struct {
+		name      string
+		v         *HMACVerifier
+		appendKey bool
+		sign      string
+	}{
+		{"md5", NewHMACMD5Verifier("secret"), false, "089D3EE798A270B098655FF0FCA4466B"},
+		{"md5 append key", NewHMACMD5Verifier("secret"), true, "3B27099C90287F9EC02A8FBACCD7612E"},
+		{"sha256", NewHMACSHA256Verifier("secret"), false, "E6596EFDD23F76FDEC1E3A247AFD395D1C4EBB152EDC0F022212964BA0E5C52E"},
+		{"sha256 append key", NewHMACSHA256Verifier("secret"), true, "AC25C441654D87CD4FD1F14EC59C596C06FC7A30F91F22A9A75B536556402C8B"},
+	}
+	for _, tt := range tests {
+		tt.v.AppendKey = tt.appendKey
+		if got := tt.v.Sign(content); got != tt.sign {
+			t.Errorf("%s: Sign = %s, want %s", tt.name, got, tt.sign)
+		}
+		if err := tt.v.VerifyContent(content, tt.sign); err != nil {
+			t.Errorf("%s: VerifyContent: %v", tt.name, err)
+		}
+
+		// 签名不区分大小写
+		params := url.Values{"appid": {"wx1"}, "body": {"test"}, "nonce_str": {"abc"}, "sign": {strings.ToLower(tt.sign)}}
+		if err := tt.v.Verify(&testDpo{values: params}); err != nil {
+			t.Errorf("%s: Verify: %v", tt.name, err)
+		}
+		params.Set("body", "changed")
+		if err := tt.v.Verify(&testDpo{values: params}); err != ErrInvalidSign {
+			t.Errorf("%s: Verify changed params = %v, want ErrInvalidSign", tt.name, err)
+		}
+		if err := tt.v.VerifyContent(content, ""); err != ErrMissingSign {
+			t.Errorf("%s: VerifyContent without sign = %v, want ErrMissingSign", tt.name, err)
+		}
+	}
+}
+
+func TestHMACVerifierFields(t *testing.T) {
+	v := &HMACVerifier{Key: "secret", Hash: sha256.New, Field: "signature", Exclude: []string{"signature", "ts"}}
+	sign := v.Sign("appid=wx1&body=test&nonce_str=abc")
+	params := url.Values{"appid": {"wx1"}, "body": {"test"}, "nonce_str": {"abc"}, "ts": {"1"}, "signature": {sign}}
+	if err := v.Verify(&testDpo{values: params}); err != nil {
+		t.Errorf("Verify with custom field: %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package thirdparty
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/micro/store"
+)
+
+var (
+	// ErrOrderProcessing 订单正在处理中(另一个通知尚未处理完成)
+	ErrOrderProcessing = errors.New("thirdparty: order is processing")
+	// ErrOrderUnmarked 处理函数已执行成功，但未能将订单标记为已处理
+	// 回调应应答成功；记录在处理超时后可被再次处理，需人工核对
+	ErrOrderUnmarked = errors.New("thirdparty: order processed but not marked done")
+)
+
+// 订单状态
+const (
+	orderProcessing = 0
+	orderDone       = 1
+)
+
+// orderTimeout 未调用SetTimeout时处理中记录的超时时长
+const orderTimeout = time.Minute * 10
+
+// Orders 已处理订单的记录，使重复的回调通知只发放一次
+// 记录保存在store的数据表中，多个服务实例共享
+type Orders struct {
+	table   string
+	timeout time.Duration
+}
+
+// NewOrders 创建订单记录(需先调用store.Init)
+func NewOrders(table string) (*Orders, error) {
+	err := store.ExecuteNow(`create table if not exists ` + store.Ignore(table) +
+		`(id varchar(128) primary key,state smallint not null,created bigint not null)`)
+	if err != nil {
+		return nil, err
+	}
+	return &Orders{table: store.Ignore(table), timeout: orderTimeout}, nil
+}
+
+// SetTimeout 设置处理中记录的超时时长
+// 处理中的记录超时后(如处理时进程崩溃)视为未处理，下次通知时重新处理
+func (o *Orders) SetTimeout(d time.Duration) {
+	o.timeout = d
+}
+
+// Once 订单未处理过时执行f，f成功后将订单标记为已处理
+// 订单已处理过时不执行f并返回dup=true，回调应直接应答成功
+// f失败时撤销记录，等待第三方再次通知；
+// f成功但标记失败时返回dup=true及ErrOrderUnmarked，回调同样应答成功
+func (o *Orders) Once(id string, f func() error) (dup bool, err error) {
+	const RETRY = 3
+
+	now := time.Now().Unix()
+	n, err := store.ExecuteRows(`insert into `+o.table+`(id,state,created) values($1,$2,$3) on conflict(id) do nothing`,
+		id, orderProcessing, now)
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		// 接管处理超时的记录
+		n, err = store.ExecuteRows(`update `+o.table+` set created=$1 where id=$2 and state=$3 and created<$4`,
+			now, id, orderProcessing, now-int64(o.timeout/time.Second))
+		if err != nil {
+			return false, err
+		}
+	}
+	if n == 0 {
+		done, err := o.Done(id)
+		if err != nil {
+			return false, err
+		}
+		if !done {
+			return false, ErrOrderProcessing
+		}
+		return true, nil
+	}
+
+	if err := f(); err != nil {
+		// 只撤销本次写入的记录，超时后已被其他通知接管的记录不受影响
+		store.ExecuteRows(`delete from `+o.table+` where id=$1 and state=$2 and created=$3`, id, orderProcessing, now)
+		return false, err
+	}
+	for i := 0; i < RETRY; i++ {
+		if _, err = store.ExecuteRows(`update `+o.table+` set state=$1 where id=$2`, orderDone, id); err == nil {
+			return false, nil
+		}
+	}
+	return true, ErrOrderUnmarked
+}
+
+// Done 订单是否已处理
+func (o *Orders) Done(id string) (bool, error) {
+	done := false
+	err := store.Query(func(r *sql.Rows) error {
+		var state int
+		if err := r.Scan(&state); err != nil {
+			return err
+		}
+		done = state == orderDone
+		return nil
+	}, `select state from `+o.table+` where id=$1`, id)
+	return done, err
+}
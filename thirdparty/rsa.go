@@ -0,0 +1,122 @@
+package thirdparty
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+
+	"github.com/micro"
+)
+
+// ErrInvalidPublicKey 公钥无效
+var ErrInvalidPublicKey = errors.New("thirdparty: invalid rsa public key")
+
+// RSAVerifier RSA(SHA1)/RSA2(SHA256)签名校验器(支付宝等)
+// 待签名串为除签名及签名类型外的排序参数，签名为base64编码
+type RSAVerifier struct {
+	Key  *rsa.PublicKey
+	Hash crypto.Hash
+
+	// 签名参数名称，为空时使用sign
+	Field string
+	// 不参与签名的参数，为空时排除sign及sign_type
+	Exclude []string
+}
+
+// NewRSAVerifier 创建RSA(SHA1)签名校验器
+// key 公钥，PEM格式或不带头尾的base64文本
+func NewRSAVerifier(key string) (*RSAVerifier, error) {
+	return newRSAVerifier(key, crypto.SHA1)
+}
+
+// NewRSA2Verifier 创建RSA2(SHA256)签名校验器
+func NewRSA2Verifier(key string) (*RSAVerifier, error) {
+	return newRSAVerifier(key, crypto.SHA256)
+}
+
+func newRSAVerifier(key string, hash crypto.Hash) (*RSAVerifier, error) {
+	pub, err := ParsePublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &RSAVerifier{Key: pub, Hash: hash}, nil
+}
+
+// ParsePublicKey 解析RSA公钥(PKIX/PKCS1，PEM格式或base64文本)
+func ParsePublicKey(key string) (*rsa.PublicKey, error) {
+	var der []byte
+	if block, _ := pem.Decode([]byte(key)); block != nil {
+		der = block.Bytes
+	} else {
+		b, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, ErrInvalidPublicKey
+		}
+		der = b
+	}
+
+	if pub, err := x509.ParsePKIXPublicKey(der); err == nil {
+		if k, ok := pub.(*rsa.PublicKey); ok {
+			return k, nil
+		}
+		return nil, ErrInvalidPublicKey
+	}
+	if cert, err := x509.ParseCertificate(der); err == nil {
+		if k, ok := cert.PublicKey.(*rsa.PublicKey); ok {
+			return k, nil
+		}
+		return nil, ErrInvalidPublicKey
+	}
+	k, err := x509.ParsePKCS1PublicKey(der)
+	if err != nil {
+		return nil, ErrInvalidPublicKey
+	}
+	return k, nil
+}
+
+// Verify 校验签名
+func (v *RSAVerifier) Verify(dpo micro.HTTPDpo) error {
+	params, err := Params(dpo)
+	if err != nil {
+		return err
+	}
+	field := v.Field
+	if field == "" {
+		field = SignField
+	}
+	exclude := v.Exclude
+	if len(exclude) == 0 {
+		exclude = []string{SignField, SignTypeField}
+	}
+	return v.VerifyContent(SignContent(params, exclude...), params[field])
+}
+
+// VerifyContent 校验待签名串的签名
+func (v *RSAVerifier) VerifyContent(content, sign string) error {
+	if sign == "" {
+		return ErrMissingSign
+	}
+	sig, err := base64.StdEncoding.DecodeString(sign)
+	if err != nil {
+		return ErrInvalidSign
+	}
+
+	var digest []byte
+	switch v.Hash {
+	case crypto.SHA1:
+		sum := sha1.Sum([]byte(content))
+		digest = sum[:]
+	default:
+		sum := sha256.Sum256([]byte(content))
+		digest = sum[:]
+	}
+	if rsa.VerifyPKCS1v15(v.Key, v.Hash, digest, sig) != nil {
+		return ErrInvalidSign
+	}
+	return nil
+}
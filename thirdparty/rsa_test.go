@@ -0,0 +1,102 @@
+package thirdparty
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/url"
+	"testing"
+)
+
+// rsaSign 以私钥签名待签名串(base64)
+func rsaSign(t *testing.T, key *rsa.PrivateKey, hash crypto.Hash, content string) string {
+	t.Helper()
+	var digest []byte
+	if hash == crypto.SHA1 {
+		sum := sha1.Sum([]byte(content))
+		digest = sum[:]
+	} else {
+		sum := sha256.Sum256([]byte(content))
+		digest = sum[:]
+	}
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, hash, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+func TestParsePublicKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkix, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	pkcs1 := x509.MarshalPKCS1PublicKey(&key.PublicKey)
+
+	tests := []struct {
+		name string
+		key  string
+		ok   bool
+	}{
+		{"pkix pem", string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pkix})), true},
+		{"pkix base64", base64.StdEncoding.EncodeToString(pkix), true},
+		{"pkcs1 pem", string(pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: pkcs1})), true},
+		{"pkcs1 base64", base64.StdEncoding.EncodeToString(pkcs1), true},
+		{"invalid base64", "not-base64!", false},
+		{"invalid der", base64.StdEncoding.EncodeToString([]byte("key")), false},
+	}
+	for _, tt := range tests {
+		pub, err := ParsePublicKey(tt.key)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v, want ok %v", tt.name, err, tt.ok)
+		} else if tt.ok && pub.N.Cmp(key.N) != 0 {
+			t.Errorf("%s: parsed another key", tt.name)
+		}
+	}
+}
+
+func TestRSAVerifier(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkix, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	pub := base64.StdEncoding.EncodeToString(pkix)
+	rsa1, err := NewRSAVerifier(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsa2, err := NewRSA2Verifier(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const content = "app_id=2021&out_trade_no=o1&total_amount=1.00"
+	params := func(sign, signType string) url.Values {
+		return url.Values{"app_id": {"2021"}, "out_trade_no": {"o1"}, "total_amount": {"1.00"}, "sign": {sign}, "sign_type": {signType}}
+	}
+	tests := []struct {
+		name   string
+		v      *RSAVerifier
+		params url.Values
+		err    error
+	}{
+		{"rsa", rsa1, params(rsaSign(t, key, crypto.SHA1, content), "RSA"), nil},
+		{"rsa2", rsa2, params(rsaSign(t, key, crypto.SHA256, content), "RSA2"), nil},
+		{"rsa2 signed by sha1", rsa2, params(rsaSign(t, key, crypto.SHA1, content), "RSA2"), ErrInvalidSign},
+		{"tampered", rsa2, params(rsaSign(t, key, crypto.SHA256, content+"0"), "RSA2"), ErrInvalidSign},
+		{"invalid base64", rsa2, params("!!", "RSA2"), ErrInvalidSign},
+		{"missing sign", rsa2, params("", "RSA2"), ErrMissingSign},
+	}
+	for _, tt := range tests {
+		if err := tt.v.Verify(&testDpo{values: tt.params}); err != tt.err {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
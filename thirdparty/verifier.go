@@ -0,0 +1,162 @@
+// Package thirdparty 第三方回调(支付通知等)的签名校验及订单幂等处理
+package thirdparty
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/micro"
+)
+
+var (
+	// ErrInvalidSign 签名无效
+	ErrInvalidSign = errors.New("thirdparty: invalid sign")
+	// ErrMissingSign 缺少签名参数
+	ErrMissingSign = errors.New("thirdparty: missing sign")
+)
+
+// 默认的签名参数名称
+const (
+	SignField     = `sign`
+	SignTypeField = `sign_type`
+)
+
+// Verifier 回调签名校验器
+type Verifier interface {
+	Verify(dpo micro.HTTPDpo) error
+}
+
+// WithVerifier 为Route或third-part/接口设置签名校验
+// 校验失败时不调用业务函数，直接以fail及typ(json/xml/string)响应
+func WithVerifier(v Verifier, fail interface{}, typ string) micro.Option {
+	return micro.WithMiddleware(verifyMiddleware(v, fail, typ))
+}
+
+// verifyMiddleware 签名校验中间件
+func verifyMiddleware(v Verifier, fail interface{}, typ string) micro.Middleware {
+	return func(api string, next micro.Handler) micro.Handler {
+		return func(dpo micro.Dpo) (interface{}, string) {
+			hd, ok := dpo.(micro.HTTPDpo)
+			if !ok {
+				return fail, typ
+			}
+			if err := v.Verify(hd); err != nil {
+				micro.Debug("third-part [%s] verify error: %v", api, err)
+				return fail, typ
+			}
+			return next(dpo)
+		}
+	}
+}
+
+// Params 回调参数
+// 消息体为XML或JSON对象时取第一层的值，否则取查询参数及表单参数
+func Params(dpo micro.HTTPDpo) (map[string]string, error) {
+	body := bytes.TrimSpace(dpo.Body())
+	if len(body) > 0 {
+		switch body[0] {
+		case '<':
+			return xmlParams(body)
+		case '{':
+			return jsonParams(body)
+		}
+	}
+	values := dpo.Values()
+	params := make(map[string]string, len(values))
+	for k, vs := range values {
+		if len(vs) > 0 {
+			params[k] = vs[0]
+		}
+	}
+	return params, nil
+}
+
+// xmlParams 解析<xml><k>v</k></xml>格式的参数
+func xmlParams(body []byte) (map[string]string, error) {
+	var (
+		params = make(map[string]string, 16)
+		dec    = xml.NewDecoder(bytes.NewReader(body))
+		depth  int
+		name   string
+		value  bytes.Buffer
+	)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return params, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 2 {
+				name = t.Name.Local
+				value.Reset()
+			}
+		case xml.CharData:
+			if depth == 2 {
+				value.Write(t)
+			}
+		case xml.EndElement:
+			if depth == 2 {
+				params[name] = value.String()
+			}
+			depth--
+		}
+	}
+}
+
+// jsonParams 解析JSON对象第一层的参数，非字符串的值保留原始文本
+func jsonParams(body []byte) (map[string]string, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, err
+	}
+	params := make(map[string]string, len(raw))
+	for k, v := range raw {
+		var s string
+		if len(v) > 0 && v[0] == '"' && json.Unmarshal(v, &s) == nil {
+			params[k] = s
+		} else {
+			params[k] = string(v)
+		}
+	}
+	return params, nil
+}
+
+// SignContent 待签名串
+// 参数按名称排序，忽略空值及exclude中的参数，以k=v&k=v拼接
+func SignContent(params map[string]string, exclude ...string) string {
+	keys := make([]string, 0, len(params))
+next:
+	for k, v := range params {
+		if v == "" {
+			continue
+		}
+		for _, e := range exclude {
+			if k == e {
+				continue next
+			}
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			sb.WriteByte('&')
+		}
+		sb.WriteString(k)
+		sb.WriteByte('=')
+		sb.WriteString(params[k])
+	}
+	return sb.String()
+}
@@ -0,0 +1,98 @@
+package thirdparty
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/micro"
+)
+
+// testDpo 只提供消息体及参数的HTTPDpo
+type testDpo struct {
+	micro.HTTPDpo
+	body   string
+	values url.Values
+}
+
+func (d *testDpo) Body() []byte       { return []byte(d.body) }
+func (d *testDpo) Values() url.Values { return d.values }
+
+func TestSignContent(t *testing.T) {
+	params := map[string]string{"b": "2", "a": "1", "sign": "x", "sign_type": "RSA2", "empty": "", "C": "3"}
+	tests := []struct {
+		exclude []string
+		want    string
+	}{
+		{nil, "C=3&a=1&b=2&sign=x&sign_type=RSA2"},
+		{[]string{"sign"}, "C=3&a=1&b=2&sign_type=RSA2"},
+		{[]string{"sign", "sign_type"}, "C=3&a=1&b=2"},
+		{[]string{"a", "b", "C", "sign", "sign_type"}, ""},
+	}
+	for _, tt := range tests {
+		if got := SignContent(params, tt.exclude...); got != tt.want {
+			t.Errorf("SignContent(exclude %v) = %q, want %q", tt.exclude, got, tt.want)
+		}
+	}
+}
+
+func TestParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		values url.Values
+		want   map[string]string
+		err    bool
+	}{
+		{"xml", `<xml><appid><![CDATA[wx1]]></appid><total_fee>100</total_fee><sign>S</sign></xml>`, nil,
+			map[string]string{"appid": "wx1", "total_fee": "100", "sign": "S"}, false},
+		{"xml nested", `<xml><a>1</a><b><c>2</c></b><d></d></xml>`, nil,
+			map[string]string{"a": "1", "b": "", "d": ""}, false},
+		{"xml invalid", `<xml><a>1</b></xml>`, nil, nil, true},
+		{"json", ` {"out_trade_no":"o1","amount":100,"paid":true,"extra":{"k":"v"},"none":null}`, nil,
+			map[string]string{"out_trade_no": "o1", "amount": "100", "paid": "true", "extra": `{"k":"v"}`, "none": "null"}, false},
+		{"json invalid", `{"a":`, nil, nil, true},
+		{"form", `a=1&b=2`, url.Values{"a": {"1"}, "b": {"2", "3"}, "c": {}},
+			map[string]string{"a": "1", "b": "2"}, false},
+	}
+	for _, tt := range tests {
+		got, err := Params(&testDpo{body: tt.body, values: tt.values})
+		if (err != nil) != tt.err {
+			t.Errorf("%s: err = %v, want error %v", tt.name, err, tt.err)
+		} else if !tt.err && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: params = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWithVerifier(t *testing.T) {
+	v := NewHMACMD5Verifier("secret")
+	v.AppendKey = true
+	const sign = "3B27099C90287F9EC02A8FBACCD7612E"
+
+	called := false
+	handler := verifyMiddleware(v, "FAIL", "string")("notify", func(dpo micro.Dpo) (interface{}, string) {
+		called = true
+		return "SUCCESS", "string"
+	})
+
+	tests := []struct {
+		name   string
+		dpo    micro.Dpo
+		resp   interface{}
+		called bool
+	}{
+		{"valid", &testDpo{body: `<xml><appid>wx1</appid><body>test</body><nonce_str>abc</nonce_str><sign>` + sign + `</sign></xml>`}, "SUCCESS", true},
+		{"bad sign", &testDpo{body: `<xml><appid>wx1</appid><body>test</body><nonce_str>abc</nonce_str><sign>00</sign></xml>`}, "FAIL", false},
+		{"tampered", &testDpo{body: `<xml><appid>wx2</appid><body>test</body><nonce_str>abc</nonce_str><sign>` + sign + `</sign></xml>`}, "FAIL", false},
+		{"missing sign", &testDpo{body: `<xml><appid>wx1</appid></xml>`}, "FAIL", false},
+		{"not http", nil, "FAIL", false},
+	}
+	for _, tt := range tests {
+		called = false
+		resp, typ := handler(tt.dpo)
+		if resp != tt.resp || typ != "string" || called != tt.called {
+			t.Errorf("%s: resp = %v %s, called %v; want %v, called %v", tt.name, resp, typ, called, tt.resp, tt.called)
+		}
+	}
+}
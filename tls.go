@@ -0,0 +1,133 @@
+package micro
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// errTLSInvalidCA CA证书无效
+var errTLSInvalidCA = errors.New("tls: invalid ca certificate")
+
+// tlsConfig TLS配置
+// 配置了CA证书时，服务间连接(rpc/registry/uploader/closer)使用双向TLS
+type tlsConfig struct {
+	enabled bool
+	certs   atomic.Value
+	ca      *x509.CertPool
+	server  *tls.Config
+	client  *tls.Config
+}
+
+// Init 加载证书
+func (t *tlsConfig) Init() error {
+	t.enabled = false
+	if len(env.config.TLSCerts) < 2 {
+		return nil
+	}
+	if err := t.Reload(); err != nil {
+		return err
+	}
+
+	t.ca = nil
+	if env.config.TLSCA != "" {
+		data, err := ioutil.ReadFile(env.config.TLSCA)
+		if err != nil {
+			return err
+		}
+		t.ca = x509.NewCertPool()
+		if !t.ca.AppendCertsFromPEM(data) {
+			return errTLSInvalidCA
+		}
+	}
+
+	t.server = &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: t.getCertificate,
+	}
+	if t.ca != nil {
+		t.server.ClientCAs = t.ca
+		t.server.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	t.client = &tls.Config{
+		MinVersion:           tls.VersionTLS12,
+		RootCAs:              t.ca,
+		ServerName:           env.config.TLSServerName,
+		GetClientCertificate: t.getClientCertificate,
+	}
+	t.enabled = true
+	return nil
+}
+
+// Reload 重新加载证书
+func (t *tlsConfig) Reload() error {
+	certs := make([]tls.Certificate, 0, len(env.config.TLSCerts)/2)
+	for i := 1; i < len(env.config.TLSCerts); i += 2 {
+		cert, err := tls.LoadX509KeyPair(env.config.TLSCerts[i-1], env.config.TLSCerts[i])
+		if err != nil {
+			return err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) > 0 {
+		t.certs.Store(certs)
+	}
+	return nil
+}
+
+// getCertificate 按SNI选择证书，没有匹配时使用第一个证书
+func (t *tlsConfig) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certs := t.certs.Load().([]tls.Certificate)
+	for i := range certs {
+		if hello.SupportsCertificate(&certs[i]) == nil {
+			return &certs[i], nil
+		}
+	}
+	return &certs[0], nil
+}
+
+// getClientCertificate 服务间连接使用的客户端证书
+func (t *tlsConfig) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	certs := t.certs.Load().([]tls.Certificate)
+	return &certs[0], nil
+}
+
+// Listen 监听地址
+func (t *tlsConfig) Listen(address string) (net.Listener, error) {
+	lsr, err := net.Listen("tcp", address)
+	if err != nil || !t.enabled {
+		return lsr, err
+	}
+	return tls.NewListener(lsr, t.server), nil
+}
+
+// Dial 连接其他服务
+func (t *tlsConfig) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	if !t.enabled {
+		return net.DialTimeout("tcp", address, timeout)
+	}
+
+	cfg := t.client
+	if cfg.ServerName == "" {
+		cfg = cfg.Clone()
+		cfg.ServerName, _, _ = net.SplitHostPort(address)
+	}
+	return tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", address, cfg)
+}
+
+// Verified 服务间连接是否通过了客户端证书校验
+func (t *tlsConfig) Verified(conn net.Conn, upgrade string) bool {
+	if !t.enabled || t.ca == nil {
+		return true
+	}
+	switch upgrade {
+	case "rpc", "registry", "registry-peer", "uploader", "closer":
+		tc, ok := conn.(*tls.Conn)
+		return ok && len(tc.ConnectionState().VerifiedChains) > 0
+	}
+	return true
+}
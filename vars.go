@@ -17,44 +17,81 @@ var (
 		ErrCode: "NoLogin",
 	}
 
+	// forbiddenError 会话角色没有访问权限
+	forbiddenError = &errBisResp{
+		ErrCode: "Forbidden",
+	}
+
+	// rateLimitedError 请求过于频繁
+	rateLimitedError = &errBisResp{
+		ErrCode: "RateLimited",
+	}
+
+	// serverClosingError 服务器正在关闭
+	serverClosingError = &errBisResp{
+		ErrCode: "ServerClosing",
+	}
+
 	// errRPCNotFoundService RPC调用没有发现服务
 	errRPCNotFoundService = errors.New("rpc: not found service")
 
 	// errWSHDError WebSocket无效的Token
 	errWSInvalidToken = errors.New(`ws: token invalid`)
+	// errWSProtocol WebSocket帧不符合协议
+	errWSProtocol = errors.New(`ws: protocol error`)
+	// errWSInflateLimit WebSocket解压后的消息过大
+	errWSInflateLimit = errors.New(`ws: inflated message too large`)
+
+	// errFormTarget 表单只能解码到结构体或map的指针
+	errFormTarget = errors.New("form: decode target must be a pointer to struct or map")
 
 	// errUploadError 文件上传错误
 	errUploadError = errors.New("update: upload file painc")
 )
 
+// httpBinaryType 二进制模式的Content-Type
+const httpBinaryType = "application/x-micro-binary"
+
+// httpFormType 表单的Content-Type
+const httpFormType = "application/x-www-form-urlencoded"
+
+// http头域名称
+const (
+	httpHeaderAPI            = "Api"
+	httpHeaderAcceptEncoding = "Accept-Encoding"
+	httpHeaderContentType    = "Content-Type"
+)
+
 var (
-	httpOption           = []byte("OPTIONS ")
 	httpUpgrade          = []byte("Upgrade: ")
 	httpRPCUpgrade       = []byte("Upgrade: rpc")
 	httpRegistryUpgrade  = []byte("Upgrade: registry")
+	httpRegistryPeer     = []byte("Upgrade: registry-peer")
 	httpAuthorize        = []byte("Authorize: ")
 	httpRemoteAddress    = []byte("Remote-Addr: ")
 	httpRegistryPort     = []byte("ServerPort: ")
-	httpPathStart        = []byte{' ', '/'}
-	httpPathEnd          = []byte{' '}
+	httpRegistryWeight   = []byte("ServerWeight: ")
 	httpContentLength    = []byte("Content-Length: ")
 	httpConnectionClose  = []byte("Connection: close\r\n")
 	httpAPI              = []byte("Api: ")
 	httpRowAt            = []byte{'\r', '\n'}
-	httpAcceptEncoding   = []byte("Accept-Encoding: ")
 	httpAcceptZib        = []byte("zlib")
 	httpUID              = []byte("UID: ")
+	httpSession          = []byte("Session: ")
+	httpContentType      = []byte("Content-Type: ")
 	httpRanges           = []byte("Range: ")
 	httpRespOk           = []byte("HTTP/1.1 200 OK\r\n")
-	httpRespOkAccess     = []byte("HTTP/1.1 200 OK\r\nAccess-Control-Allow-Origin: *\r\nAccess-Control-Expose-Headers: Api,UID\r\nAccess-Control-Allow-Headers: Api,UID\r\n")
+	httpRespOkAccess     = []byte("HTTP/1.1 200 OK\r\nAccess-Control-Allow-Origin: *\r\nAccess-Control-Expose-Headers: Api,UID,Session\r\nAccess-Control-Allow-Headers: Api,UID,Session,Content-Type\r\n")
 	httpRespOk206        = []byte("HTTP/1.1 206 OK\r\n")
 	httpRespOk404        = []byte("HTTP/1.1 404 Not Found\r\n")
+	httpRespBad400       = []byte("HTTP/1.1 400 Bad Request\r\n")
 	httpRespAcceptRanges = []byte("Accept-Ranges: bytes\r\n")
 	httpRespRanges       = []byte("Content-Range: bytes ")
 	httpRespEncoding     = []byte("Content-Encoding: zlib\r\n")
 	httpRespContent0     = []byte("Content-Length: 0\r\n")
 	httpRespStream       = []byte("Content-Type: application/octet-stream\r\n")
 	httpRespJSON         = []byte("Content-Type: application/json; charset=utf-8\r\n")
+	httpRespBinary       = []byte("Content-Type: " + httpBinaryType + "\r\n")
 	httpRespHTML         = []byte("Content-Type: text/html; charset=utf-8\r\n")
 	httpRespCSS          = []byte("Content-Type: text/css; charset=utf-8\r\n")
 	httpRespPNG          = []byte("Content-Type: image/png\r\n")
@@ -62,12 +99,14 @@ var (
 	httpRespGIF          = []byte("Content-Type: image/gif\r\n")
 	httpRespICO          = []byte("Content-Type: image/x-icon\r\n")
 	httpRespAppCache     = []byte("Content-Type: text/cache-manifest\r\n")
+	httpRespMetrics      = []byte("Content-Type: text/plain; version=0.0.4; charset=utf-8\r\n")
 	httpRespErrorPrefix  = []byte(`{"ErrCode":"`)
 	httpRespErrorSuffix  = []byte(`"}`)
 	wsRespOK             = []byte("HTTP/1.1 101 Web Socket Protocol Handshake\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
 	wsKey                = []byte(`Sec-WebSocket-Key: `)
 	wsAccept             = []byte("Sec-WebSocket-Accept: ")
 	wsProtocol           = []byte("Sec-WebSocket-Protocol: ")
+	wsExtensions         = []byte("Sec-WebSocket-Extensions: ")
 	wsPing               = []byte{0x89, 0x00}
 	wsPong               = []byte{0x8a, 0x00}
 )